@@ -0,0 +1,186 @@
+package wsrpc
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type (
+	// Identity is who a client connected as, established by
+	// Config.Authenticate.
+	Identity struct {
+		// Unique ID of the user, such as the subject of a token.
+		Subject string
+		// Token the client presented, if any.
+		Token *oauth2.Token
+		// Roles and scopes granted to the user, checked against the Policy
+		// of each method called.
+		Roles  []string
+		Scopes []string
+		// Any other application data about the user.
+		Extra interface{}
+	}
+
+	// Authenticator checks the credentials of a request to connect, returning
+	// the identity of the user. An error with status code PermissionDenied
+	// refuses the connection with 403 Forbidden and any other error with 401
+	// Unauthorized.
+	//
+	// Browsers can't set headers on a WebSocket request so credentials
+	// usually come in a cookie or the query string. See BearerToken.
+	Authenticator func(r *http.Request) (*Identity, error)
+
+	// Refresher checks a token sent by a connected client to replace the one
+	// it authenticated with, returning the new identity. The current identity
+	// is the one the client has until the refresh succeeds. Errors are
+	// reported to the client as with Authenticator.
+	Refresher func(ctx context.Context, token string, current *Identity) (*Identity, error)
+)
+
+// BearerToken returns the OAuth 2 bearer token sent with a request in the
+// Authorization header or, since browsers can't set that header for a
+// WebSocket, the access_token query parameter. It returns an empty string if
+// there is neither.
+func BearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	if h := r.Header.Get("Authorization"); len(h) > len(prefix) && strings.EqualFold(h[:len(prefix)], prefix) {
+		return h[len(prefix):]
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// IdentityFromContext returns the identity of the client making a call. It's
+// false if the server has no Config.Authenticate or ctx isn't a call context.
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	c, ok := ClientFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	id := c.Identity()
+	return id, id != nil
+}
+
+// authenticate runs the configured Authenticator, if any, writing an error
+// response and returning false if the request is refused.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*Identity, bool) {
+	if s.config.Authenticate == nil {
+		return nil, true
+	}
+	id, err := s.config.Authenticate(r)
+	if err == nil && id == nil {
+		err = status.Error(codes.Unauthenticated, "no identity")
+	}
+	if err != nil {
+		code := http.StatusUnauthorized
+		if status.Code(err) == codes.PermissionDenied {
+			code = http.StatusForbidden
+		}
+		s.log.Info("wsrpc: connection refused", logRemote, r.RemoteAddr, logError, err)
+		http.Error(w, http.StatusText(code), code)
+		return nil, false
+	}
+	return id, true
+}
+
+// CloseTokenExpired is the WebSocket close code sent when a connection's
+// token expires without the client refreshing it. Codes from 4000 are for
+// applications to define.
+const CloseTokenExpired = 4001
+
+// Identity returns who the client authenticated as, or nil if the server has
+// no Config.Authenticate. It changes when the client refreshes its token.
+func (c *Client) Identity() *Identity {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.identity
+}
+
+// setIdentity replaces the client identity.
+func (c *Client) setIdentity(id *Identity) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.identity = id
+}
+
+// refresh re-authenticates the client with the token in a control Call using
+// Config.Refresh or, without it, Config.Authenticate as described by
+// refreshRequest. The new identity must be for the same subject.
+func (c *Client) refresh(call *Call) {
+	s := c.server
+	var (
+		id  *Identity
+		err error
+	)
+	switch {
+	case s.config.Refresh != nil:
+		id, err = s.config.Refresh(c.ctx, call.AccessToken, c.Identity())
+	case s.config.Authenticate != nil:
+		id, err = s.config.Authenticate(c.refreshRequest(call.AccessToken))
+	default:
+		s.finish(c, &Reply{Id: call.Id}, status.Error(codes.FailedPrecondition, "wsrpc: the server doesn't authenticate"))
+		return
+	}
+	if err == nil && id == nil {
+		err = status.Error(codes.Unauthenticated, "no identity")
+	}
+	if cur := c.Identity(); err == nil && cur != nil && id.Subject != cur.Subject {
+		err = status.Error(codes.PermissionDenied, "wsrpc: a refreshed token must be for the same subject")
+	}
+	if err != nil {
+		if _, ok := status.FromError(err); !ok {
+			err = status.Error(codes.Unauthenticated, err.Error())
+		}
+		c.log.Info("wsrpc: token refresh refused", logError, err)
+		s.finish(c, &Reply{Id: call.Id}, err)
+		return
+	}
+
+	c.setIdentity(id)
+	c.expireAt(id)
+	c.log.Debug("wsrpc: token refreshed")
+	s.finish(c, &Reply{Id: call.Id}, nil)
+}
+
+// refreshRequest returns a copy of the original upgrade request carrying only
+// the refreshed token, as its bearer token, for passing to the Authenticator
+// again. Cookies are removed so the Authenticator can't find the credential
+// the client connected with instead, which means an Authenticator used with
+// refreshes should read the token with BearerToken.
+func (c *Client) refreshRequest(token string) *http.Request {
+	r := c.request.Clone(c.ctx)
+	r.Header.Del("Cookie")
+	r.Header.Set("Authorization", "Bearer "+token)
+	q := r.URL.Query()
+	q.Set("access_token", token)
+	r.URL.RawQuery = q.Encode()
+	return r
+}
+
+// expireAt arranges for the connection to close with CloseTokenExpired when
+// the identity's token expires, replacing any earlier arrangement.
+func (c *Client) expireAt(id *Identity) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.expiry != nil {
+		c.expiry.Stop()
+		c.expiry = nil
+	}
+	if id == nil || id.Token == nil || id.Token.Expiry.IsZero() {
+		return
+	}
+	c.expiry = time.AfterFunc(time.Until(id.Token.Expiry), func() {
+		c.log.Info("wsrpc: token expired")
+		c.server.disconnect(c, CloseTokenExpired, "token expired")
+	})
+}
+
+// stopExpiry cancels closing the connection when the token expires.
+func (c *Client) stopExpiry() {
+	c.expireAt(nil)
+}
@@ -0,0 +1,250 @@
+package wsrpc_test
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/toba/wsrpc"
+	plugin "github.com/toba/wsrpc/cmd/protoc-gen-gows/test"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// tokenAuth accepts the bearer token "good" as user "alice" and forbids "banned".
+func tokenAuth(r *http.Request) (*wsrpc.Identity, error) {
+	switch wsrpc.BearerToken(r) {
+	case "good":
+		return &wsrpc.Identity{Subject: "alice", Token: &oauth2.Token{AccessToken: "good"}}, nil
+	case "banned":
+		return nil, status.Error(codes.PermissionDenied, "banned")
+	}
+	return nil, status.Error(codes.Unauthenticated, "bad token")
+}
+
+// whoami replies to Execute with the subject of the caller's identity.
+func whoami(t *testing.T) *mockService {
+	return &mockService{
+		t: t,
+		execute: func(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error) {
+			id, ok := wsrpc.IdentityFromContext(ctx)
+			if !ok {
+				return nil, status.Error(codes.Unauthenticated, "no identity")
+			}
+			return &plugin.SimpleResponse{Text: id.Subject}, nil
+		},
+	}
+}
+
+func TestAuthenticateRefuses(t *testing.T) {
+	addr := serve(t, serverWith(t, wsrpc.Config{Authenticate: tokenAuth}, whoami(t)))
+
+	for token, code := range map[string]int{
+		"":       http.StatusUnauthorized,
+		"wrong":  http.StatusUnauthorized,
+		"banned": http.StatusForbidden,
+	} {
+		_, res, err := websocket.DefaultDialer.Dial(addr+"?access_token="+token, nil)
+		assert.Error(t, err)
+		if assert.NotNil(t, res, token) {
+			assert.Equal(t, code, res.StatusCode, token)
+		}
+	}
+}
+
+func TestAuthenticateAfterOrigin(t *testing.T) {
+	var calls int32
+	addr := serve(t, serverWith(t, wsrpc.Config{
+		Authenticate: func(r *http.Request) (*wsrpc.Identity, error) {
+			atomic.AddInt32(&calls, 1)
+			return tokenAuth(r)
+		},
+	}, whoami(t)))
+
+	_, res, err := websocket.DefaultDialer.Dial(addr+"?access_token=good", http.Header{"Origin": {"http://evil.com"}})
+	assert.Error(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+}
+
+func TestAuthenticateIdentity(t *testing.T) {
+	addr := serve(t, serverWith(t, wsrpc.Config{Authenticate: tokenAuth}, whoami(t)))
+
+	header, _, err := websocket.DefaultDialer.Dial(addr, http.Header{"Authorization": {"Bearer good"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer header.Close()
+
+	for _, conn := range []*websocket.Conn{dialURL(t, addr+"?access_token=good"), header} {
+		call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+		r := reply(t, conn)
+		assert.Equal(t, int32(codes.OK), r.Status.GetCode())
+
+		out := &plugin.SimpleResponse{}
+		assert.NoError(t, proto.Unmarshal(r.Payload, out))
+		assert.Equal(t, "alice", out.Text)
+	}
+}
+
+// expiringAuth accepts "short" as a token expiring almost at once and "long"
+// as one lasting an hour, preferring a token in the session cookie to the
+// bearer token.
+func expiringAuth(r *http.Request) (*wsrpc.Identity, error) {
+	token := wsrpc.BearerToken(r)
+	if cookie, err := r.Cookie("session"); err == nil {
+		token = cookie.Value
+	}
+	return expiringToken(token)
+}
+
+func expiringToken(token string) (*wsrpc.Identity, error) {
+	var ttl time.Duration
+	switch token {
+	case "short":
+		ttl = 200 * time.Millisecond
+	case "long":
+		ttl = time.Hour
+	default:
+		return nil, status.Error(codes.Unauthenticated, "bad token")
+	}
+	return &wsrpc.Identity{
+		Subject: "alice",
+		Token:   &oauth2.Token{AccessToken: token, Expiry: time.Now().Add(ttl)},
+	}, nil
+}
+
+func TestTokenExpires(t *testing.T) {
+	addr := serve(t, serverWith(t, wsrpc.Config{Authenticate: expiringAuth}, &mockService{t: t}))
+	conn := dialURL(t, addr+"?access_token=short")
+
+	assert.Equal(t, wsrpc.CloseTokenExpired, closeCode(t, conn))
+}
+
+func TestTokenRefresh(t *testing.T) {
+	// Execute replies with the connect-time token then the current one
+	m := &mockService{
+		t: t,
+		execute: func(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error) {
+			c, _ := wsrpc.ClientFromContext(ctx)
+			return &plugin.SimpleResponse{Text: c.Token.AccessToken + " " + c.Identity().Token.AccessToken}, nil
+		},
+	}
+	addr := serve(t, serverWith(t, wsrpc.Config{Authenticate: expiringAuth}, m))
+	// the refresh mustn't be checked against the cookie connected with
+	conn, _, err := websocket.DefaultDialer.Dial(addr, http.Header{"Cookie": {"session=short"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	write(t, conn, &wsrpc.Call{Id: 1, AccessToken: "wrong"})
+	r := reply(t, conn)
+	assert.Equal(t, uint32(1), r.Id)
+	assert.Equal(t, int32(codes.Unauthenticated), r.Status.Code)
+
+	write(t, conn, &wsrpc.Call{Id: 1, AccessToken: "long"})
+	r = reply(t, conn)
+	assert.Equal(t, uint32(1), r.Id)
+	assert.Equal(t, int32(codes.OK), r.Status.GetCode())
+
+	// still connected after the first token would have expired
+	time.Sleep(300 * time.Millisecond)
+	call(t, conn, 2, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+	r = reply(t, conn)
+	assert.Equal(t, int32(codes.OK), r.Status.GetCode())
+	out := &plugin.SimpleResponse{}
+	assert.NoError(t, proto.Unmarshal(r.Payload, out))
+	assert.Equal(t, "short long", out.Text)
+}
+
+func TestTokenRefresher(t *testing.T) {
+	refresh := func(ctx context.Context, token string, current *wsrpc.Identity) (*wsrpc.Identity, error) {
+		assert.Equal(t, "short", current.Token.AccessToken)
+		return expiringToken(token)
+	}
+	addr := serve(t, serverWith(t, wsrpc.Config{Authenticate: expiringAuth, Refresh: refresh}, &mockService{t: t}))
+	conn := dialURL(t, addr+"?access_token=short")
+
+	write(t, conn, &wsrpc.Call{Id: 1, AccessToken: "long"})
+	assert.Equal(t, int32(codes.OK), reply(t, conn).Status.GetCode())
+
+	time.Sleep(300 * time.Millisecond)
+	call(t, conn, 2, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+	assert.Equal(t, int32(codes.OK), reply(t, conn).Status.GetCode())
+}
+
+// roleAuth gives each user named by the token the roles and scopes below.
+func roleAuth(r *http.Request) (*wsrpc.Identity, error) {
+	id := &wsrpc.Identity{Subject: wsrpc.BearerToken(r)}
+	switch id.Subject {
+	case "admin":
+		id.Roles, id.Scopes = []string{"user", "admin"}, []string{"read", "write"}
+	case "reader":
+		id.Roles, id.Scopes = []string{"admin"}, []string{"read"}
+	case "guest":
+	default:
+		return nil, status.Error(codes.Unauthenticated, "bad token")
+	}
+	return id, nil
+}
+
+func TestAuthorization(t *testing.T) {
+	rpc := wsrpc.NewServer(wsrpc.Config{
+		AllowMissingOrigin: true,
+		Authenticate:       roleAuth,
+		Policies: map[string]wsrpc.Policy{
+			// written the gRPC way
+			"/plugin_test.Test/Execute": {Roles: []string{"user"}},
+		},
+	})
+	m := &mockService{
+		t: t,
+		execute: func(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error) {
+			return &plugin.SimpleResponse{Text: in.Text}, nil
+		},
+	}
+	plugin.RegisterTestService(rpc, m)
+	addr := serve(t, rpc)
+
+	for _, test := range []struct {
+		user, method string
+		code         codes.Code
+	}{
+		{"admin", "plugin_test.Test/Admin", codes.OK},
+		{"reader", "plugin_test.Test/Admin", codes.PermissionDenied}, // missing scope
+		{"guest", "plugin_test.Test/Admin", codes.PermissionDenied},  // missing role
+		{"admin", "plugin_test.Test/Execute", codes.OK},              // policy from Config
+		{"reader", "plugin_test.Test/Execute", codes.PermissionDenied},
+		{"guest", "plugin_test.Test/Sequence", codes.OK}, // no policy
+		// a leading slash names the same method
+		{"guest", "/plugin_test.Test/Admin", codes.PermissionDenied},
+		{"reader", "/plugin_test.Test/Execute", codes.PermissionDenied},
+		{"admin", "/plugin_test.Test/Execute", codes.OK},
+	} {
+		conn, _, err := websocket.DefaultDialer.Dial(addr+"?access_token="+test.user, nil)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		call(t, conn, 1, test.method, &plugin.SimpleRequest{Text: hello})
+		r := reply(t, conn)
+		assert.Equal(t, int32(test.code), r.Status.GetCode(), "%s calling %s", test.user, test.method)
+		conn.Close()
+	}
+}
+
+func TestAuthorizationWithoutIdentity(t *testing.T) {
+	conn := connect(t, mockServer(t))
+	defer conn.Close()
+
+	call(t, conn, 1, "plugin_test.Test/Admin", &plugin.SimpleRequest{Text: hello})
+	assert.Equal(t, int32(codes.Unauthenticated), reply(t, conn).Status.Code)
+}
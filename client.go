@@ -3,44 +3,51 @@
 package wsrpc
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 
-	"strings"
-
 	"github.com/gorilla/websocket"
 	"golang.org/x/oauth2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // Client represents a connected browser.
 type Client struct {
+	// Unique ID assigned when the client connects, for use with Server.SendTo.
+	ID   string
 	conn *websocket.Conn
 	// Buffered channel of outbound messages to be picked up by the writePump.
 	Send   chan []byte
 	server *Server
-	Token  *oauth2.Token
-}
+	log    *slog.Logger // server logger with the client address
+	// Token presented when connecting, from the Identity set by
+	// Config.Authenticate. It isn't changed when the client refreshes its
+	// token; use Identity or Peer for the current one.
+	Token *oauth2.Token
+	// Closed when the server removes the client. Send is never closed since
+	// handlers may still be replying when the connection goes away.
+	done chan struct{}
+	// Close frame written by the writePump once done is closed.
+	closeMsg []byte
+	// Parent of every call context, canceled when the connection closes.
+	ctx    context.Context
+	cancel context.CancelFunc
 
-const (
-	// Time allowed to write a message to the peer.
-	writeWait = 10 * time.Second
-	// Time allowed to read the next pong message from the peer.
-	pongWait = 60 * time.Second
-	// Send pings to peer with this period. Must be less than pongWait.
-	pingPeriod = (pongWait * 9) / 10
-	// Maximum message size allowed from peer.
-	maxMessageSize = 512
-)
-
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	// CheckOrigin ensures client is allowed to connect.
-	// http://www.gorillatoolkit.org/pkg/websocket
-	CheckOrigin: func(r *http.Request) bool {
-		return strings.HasPrefix(r.RemoteAddr, "127.0.0.1") || r.Header["Origin"][0] == r.Host
-	},
+	mu       sync.Mutex
+	identity *Identity                // who the client authenticated as
+	request  *http.Request            // upgrade request, for re-authentication and Peer
+	expiry   *time.Timer              // closes the connection when the token expires
+	streams  map[uint32]*serverStream // open calls by call ID
+	running  int                      // calls handed to the server workers
+	pending  []*Request               // calls waiting for a running call to end
+	// Ordered calls waiting for the running call with the same ordering key
+	// to end. A key is present while a call with it is running.
+	sequences map[string][]*Request
+	sequenced int // number of calls waiting in sequences
 }
 
 // readPump processes messages from the client connection.
@@ -49,14 +56,17 @@ var upgrader = websocket.Upgrader{
 // per connection.
 func (c *Client) readPump() {
 	defer func() {
-		c.server.unregister <- c
+		c.cancel()
+		c.server.remove(c)
+		c.stopExpiry()
 		c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(maxMessageSize)
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	config := c.server.config
+	c.conn.SetReadLimit(config.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(config.PongWait))
 	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		c.conn.SetReadDeadline(time.Now().Add(config.PongWait))
 		return nil
 	})
 
@@ -64,14 +74,115 @@ func (c *Client) readPump() {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway) {
-				log.Printf("error: %v", err)
+				c.log.Warn("wsrpc: connection closed unexpectedly", logError, err)
+			} else {
+				c.log.Debug("wsrpc: client disconnected")
 			}
 			break
 		}
-		c.server.request <- &Request{
-			Client:     c,
-			RawMessage: message,
-			WireLength: len(message),
+		c.receive(message)
+	}
+}
+
+// receive decodes a message from the browser. A Call that names a method
+// starts a new call which is handed to the server. Any other Call belongs to a
+// stream that's already open and is delivered to it here, in the order it was
+// read, so that stream messages can't be reordered by concurrent handlers.
+func (c *Client) receive(message []byte) {
+	call := &Call{}
+	if err := c.server.codec.Unmarshal(message, call); err != nil {
+		c.server.finish(c, &Reply{}, status.Errorf(codes.InvalidArgument, "wsrpc: error unmarshalling call: %v", err))
+		return
+	}
+
+	if call.AccessToken != "" {
+		c.refresh(call)
+		return
+	}
+
+	if call.Method == "" {
+		c.mu.Lock()
+		ss, ok := c.streams[call.Id]
+		c.mu.Unlock()
+		if ok {
+			ss.deliver(call)
+		}
+		return
+	}
+
+	req := &Request{
+		Client:     c,
+		ReceivedAt: time.Now(),
+		RawMessage: message,
+		WireLength: len(message),
+		ID:         call.Id,
+		Method:     call.Method,
+		call:       call,
+	}
+	req.stream = newServerStream(c, req)
+
+	c.mu.Lock()
+	_, inUse := c.streams[call.Id]
+	if !inUse {
+		c.streams[call.Id] = req.stream
+	}
+	c.mu.Unlock()
+
+	if inUse {
+		c.server.finish(c, &Reply{Id: call.Id}, status.Errorf(codes.InvalidArgument, "wsrpc: call ID %d is already in use", call.Id))
+		return
+	}
+	if !c.server.startCall() {
+		c.closeStream(req.stream)
+		req.stream.cancel()
+		c.server.finish(c, &Reply{Id: call.Id}, status.Error(codes.Unavailable, ErrServerStopped.Error()))
+		return
+	}
+	c.order(req)
+}
+
+// close tells the writePump to send a close frame with the code and text then
+// end the connection. It must only be called once, by the server.
+func (c *Client) close(code int, text string) {
+	c.closeMsg = websocket.FormatCloseMessage(code, text)
+	close(c.done)
+}
+
+// closeStream forgets an open call so its ID can be used again. The ID is only
+// forgotten if it still belongs to the stream since the client may have
+// reused it after canceling the call.
+func (c *Client) closeStream(ss *serverStream) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.streams[ss.id] == ss {
+		delete(c.streams, ss.id)
+	}
+}
+
+// send queues a message for the writePump. It returns false if the client was
+// removed before the message could be queued.
+func (c *Client) send(res []byte) bool {
+	select {
+	case c.Send <- res:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// flush writes the messages already queued when the client is closed, such as
+// the last replies of calls a graceful stop waited for, giving up once wait
+// has passed.
+func (c *Client) flush(wait time.Duration) {
+	c.conn.SetWriteDeadline(time.Now().Add(wait))
+	for {
+		select {
+		case res := <-c.Send:
+			if err := c.conn.WriteMessage(websocket.BinaryMessage, res); err != nil {
+				return
+			}
+		default:
+			return
 		}
 	}
 }
@@ -81,23 +192,28 @@ func (c *Client) readPump() {
 // It executes in one goroutine per client ensuring there is only one writer
 // per connection.
 func (c *Client) writePump() {
-	ticker := time.NewTicker(pingPeriod)
+	writeWait := c.server.config.WriteWait
+	ticker := time.NewTicker(c.server.config.PingPeriod)
 	defer func() {
 		ticker.Stop()
+		// Once nothing writes to the connection the client must be removed,
+		// or senders such as the readPump and call handlers would wait on a
+		// full queue forever.
+		c.server.remove(c)
 		c.conn.Close()
 	}()
 
 	for {
 		select {
-		case res, ok := <-c.Send:
+		case <-c.done:
+			c.flush(writeWait)
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				// Channel has been closed.
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
+			c.conn.WriteMessage(websocket.CloseMessage, c.closeMsg)
+			return
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
+		case res := <-c.Send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			w, err := c.conn.NextWriter(websocket.BinaryMessage)
 			if err != nil {
 				return
 			}
@@ -1,21 +1,14 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // source: cmd/protoc-gen-gows/test/wsrpc.proto
 
-/*
-Package plugin_test is a generated protocol buffer package.
-
-It is generated from these files:
-	cmd/protoc-gen-gows/test/wsrpc.proto
-
-It has these top-level messages:
-	SimpleRequest
-	SimpleResponse
-*/
 package plugin_test
 
-import proto "github.com/golang/protobuf/proto"
-import fmt "fmt"
-import math "math"
+import (
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	_ "github.com/toba/wsrpc"
+	math "math"
+)
 
 import (
 	context "context"
@@ -32,27 +25,195 @@ var _ = math.Inf
 // is compatible with the proto package it is being compiled against.
 // A compilation error at this line likely means your copy of the
 // proto package needs to be updated.
-const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
 type SimpleRequest struct {
+	Text                 string   `protobuf:"bytes,1,opt,name=text,proto3" json:"text,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
-func (m *SimpleRequest) Reset()                    { *m = SimpleRequest{} }
-func (m *SimpleRequest) String() string            { return proto.CompactTextString(m) }
-func (*SimpleRequest) ProtoMessage()               {}
-func (*SimpleRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+func (m *SimpleRequest) Reset()         { *m = SimpleRequest{} }
+func (m *SimpleRequest) String() string { return proto.CompactTextString(m) }
+func (*SimpleRequest) ProtoMessage()    {}
+func (*SimpleRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3617b6979f5ee2e5, []int{0}
+}
+
+func (m *SimpleRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SimpleRequest.Unmarshal(m, b)
+}
+func (m *SimpleRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SimpleRequest.Marshal(b, m, deterministic)
+}
+func (m *SimpleRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SimpleRequest.Merge(m, src)
+}
+func (m *SimpleRequest) XXX_Size() int {
+	return xxx_messageInfo_SimpleRequest.Size(m)
+}
+func (m *SimpleRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SimpleRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SimpleRequest proto.InternalMessageInfo
+
+func (m *SimpleRequest) GetText() string {
+	if m != nil {
+		return m.Text
+	}
+	return ""
+}
 
 type SimpleResponse struct {
+	Text                 string   `protobuf:"bytes,1,opt,name=text,proto3" json:"text,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
-func (m *SimpleResponse) Reset()                    { *m = SimpleResponse{} }
-func (m *SimpleResponse) String() string            { return proto.CompactTextString(m) }
-func (*SimpleResponse) ProtoMessage()               {}
-func (*SimpleResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+func (m *SimpleResponse) Reset()         { *m = SimpleResponse{} }
+func (m *SimpleResponse) String() string { return proto.CompactTextString(m) }
+func (*SimpleResponse) ProtoMessage()    {}
+func (*SimpleResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3617b6979f5ee2e5, []int{1}
+}
+
+func (m *SimpleResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SimpleResponse.Unmarshal(m, b)
+}
+func (m *SimpleResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SimpleResponse.Marshal(b, m, deterministic)
+}
+func (m *SimpleResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SimpleResponse.Merge(m, src)
+}
+func (m *SimpleResponse) XXX_Size() int {
+	return xxx_messageInfo_SimpleResponse.Size(m)
+}
+func (m *SimpleResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_SimpleResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SimpleResponse proto.InternalMessageInfo
+
+func (m *SimpleResponse) GetText() string {
+	if m != nil {
+		return m.Text
+	}
+	return ""
+}
+
+type StreamMsg struct {
+	Text                 string   `protobuf:"bytes,1,opt,name=text,proto3" json:"text,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *StreamMsg) Reset()         { *m = StreamMsg{} }
+func (m *StreamMsg) String() string { return proto.CompactTextString(m) }
+func (*StreamMsg) ProtoMessage()    {}
+func (*StreamMsg) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3617b6979f5ee2e5, []int{2}
+}
+
+func (m *StreamMsg) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StreamMsg.Unmarshal(m, b)
+}
+func (m *StreamMsg) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StreamMsg.Marshal(b, m, deterministic)
+}
+func (m *StreamMsg) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StreamMsg.Merge(m, src)
+}
+func (m *StreamMsg) XXX_Size() int {
+	return xxx_messageInfo_StreamMsg.Size(m)
+}
+func (m *StreamMsg) XXX_DiscardUnknown() {
+	xxx_messageInfo_StreamMsg.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StreamMsg proto.InternalMessageInfo
+
+func (m *StreamMsg) GetText() string {
+	if m != nil {
+		return m.Text
+	}
+	return ""
+}
+
+type StreamMsg2 struct {
+	Text                 string   `protobuf:"bytes,1,opt,name=text,proto3" json:"text,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *StreamMsg2) Reset()         { *m = StreamMsg2{} }
+func (m *StreamMsg2) String() string { return proto.CompactTextString(m) }
+func (*StreamMsg2) ProtoMessage()    {}
+func (*StreamMsg2) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3617b6979f5ee2e5, []int{3}
+}
+
+func (m *StreamMsg2) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StreamMsg2.Unmarshal(m, b)
+}
+func (m *StreamMsg2) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StreamMsg2.Marshal(b, m, deterministic)
+}
+func (m *StreamMsg2) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StreamMsg2.Merge(m, src)
+}
+func (m *StreamMsg2) XXX_Size() int {
+	return xxx_messageInfo_StreamMsg2.Size(m)
+}
+func (m *StreamMsg2) XXX_DiscardUnknown() {
+	xxx_messageInfo_StreamMsg2.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StreamMsg2 proto.InternalMessageInfo
+
+func (m *StreamMsg2) GetText() string {
+	if m != nil {
+		return m.Text
+	}
+	return ""
+}
 
 func init() {
 	proto.RegisterType((*SimpleRequest)(nil), "plugin_test.SimpleRequest")
 	proto.RegisterType((*SimpleResponse)(nil), "plugin_test.SimpleResponse")
+	proto.RegisterType((*StreamMsg)(nil), "plugin_test.StreamMsg")
+	proto.RegisterType((*StreamMsg2)(nil), "plugin_test.StreamMsg2")
+}
+
+func init() {
+	proto.RegisterFile("cmd/protoc-gen-gows/test/wsrpc.proto", fileDescriptor_3617b6979f5ee2e5)
+}
+
+var fileDescriptor_3617b6979f5ee2e5 = []byte{
+	// 285 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x92, 0xb1, 0x4e, 0xc3, 0x30,
+	0x10, 0x86, 0x65, 0x91, 0x40, 0x7b, 0xa8, 0x0c, 0x1e, 0x80, 0x84, 0x81, 0x2a, 0x74, 0xc8, 0xd2,
+	0xa4, 0x0a, 0x23, 0x53, 0x23, 0x18, 0x18, 0x58, 0x9a, 0x32, 0xa3, 0x92, 0x9c, 0x22, 0x4b, 0x8d,
+	0x6d, 0x62, 0x47, 0xe9, 0x13, 0xe4, 0x39, 0x98, 0x59, 0x90, 0x78, 0x42, 0x94, 0xd0, 0xa2, 0x56,
+	0x72, 0x3b, 0x74, 0xb3, 0xfc, 0x7f, 0xfe, 0xfd, 0xe9, 0x74, 0x30, 0x4a, 0x8b, 0x2c, 0x94, 0xa5,
+	0xd0, 0x22, 0x1d, 0xe7, 0xc8, 0xc7, 0xb9, 0xa8, 0x55, 0xa8, 0x51, 0xe9, 0xb0, 0x56, 0xa5, 0x4c,
+	0x83, 0x2e, 0xa2, 0xe7, 0x72, 0x59, 0xe5, 0x8c, 0xbf, 0xb5, 0x81, 0x3b, 0x10, 0x52, 0x33, 0xc1,
+	0xd5, 0x5f, 0xe6, 0xdd, 0xc1, 0x20, 0x61, 0x85, 0x5c, 0xe2, 0x0c, 0x3f, 0x2a, 0x54, 0x9a, 0x52,
+	0xb0, 0x34, 0xae, 0xf4, 0x35, 0x19, 0x12, 0xbf, 0x3f, 0xeb, 0xce, 0xde, 0x08, 0x2e, 0x36, 0x90,
+	0x92, 0x82, 0x2b, 0x34, 0x52, 0xb7, 0xd0, 0x4f, 0x74, 0x89, 0x8b, 0xe2, 0x45, 0xe5, 0x46, 0x60,
+	0x08, 0xf0, 0x0f, 0x44, 0x26, 0x22, 0xfa, 0x3e, 0x01, 0x6b, 0xde, 0x5a, 0xc4, 0x70, 0xf6, 0xb4,
+	0xc2, 0xb4, 0xd2, 0x48, 0xdd, 0x60, 0x4b, 0x3f, 0xd8, 0x91, 0x75, 0x6f, 0x8c, 0xd9, 0xda, 0x31,
+	0x06, 0x78, 0x14, 0x35, 0x57, 0xdd, 0x97, 0x07, 0x6b, 0x2e, 0x77, 0xb3, 0x8d, 0xe3, 0x84, 0xd0,
+	0x29, 0xf4, 0x5e, 0xe5, 0xba, 0x61, 0x0f, 0x75, 0x50, 0xc2, 0x27, 0xf4, 0x01, 0xac, 0x98, 0x65,
+	0x6c, 0xef, 0xf3, 0x2b, 0xf3, 0x7d, 0xe4, 0x93, 0x09, 0xa1, 0xcf, 0xd0, 0x4b, 0x5a, 0x49, 0x9e,
+	0x1e, 0x3f, 0x08, 0xcf, 0xfa, 0x6c, 0x1c, 0x42, 0xe7, 0x60, 0x4f, 0xb3, 0x82, 0xf1, 0xe3, 0x7b,
+	0xe8, 0x57, 0xe3, 0xd8, 0x8b, 0xb6, 0xe3, 0xa7, 0x71, 0xec, 0xba, 0x64, 0x1a, 0xdf, 0x4f, 0xbb,
+	0x35, 0xba, 0xff, 0x1d, 0x00, 0x8a, 0xa3, 0xd5, 0x93, 0x8a, 0x02, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if not otherwise used.
@@ -62,47 +223,198 @@ var (
 	_ grpc.ClientConn
 )
 
-// Server API for Test service
+// WebSocket Server API for Test service
 
 type TestService interface {
-	Execute(context.Context) (*SimpleResponse, error)
+	Execute(context.Context, *SimpleRequest) (*SimpleResponse, error)
+	// This RPC streams from the server only.
+	Downstream(*SimpleRequest, Test_DownstreamServer) error
+	// This RPC streams from the client.
+	Upstream(Test_UpstreamServer) error
+	// This one streams in both directions.
+	Bidi(Test_BidiServer) error
+	// Calls to this RPC run in order for each client.
+	Sequence(context.Context, *SimpleRequest) (*SimpleResponse, error)
+	// Only admins with write access may call this RPC.
+	Admin(context.Context, *SimpleRequest) (*SimpleResponse, error)
 }
 
 func RegisterTestService(s *wsrpc.Server, srv TestService) {
 	s.RegisterService(&TestServiceDescriptor, srv)
 }
 
-func TestExecuteHandler(srv interface{}, ctx context.Context, decode func(interface{}) error) (interface{}, error) {
+// TryRegisterTestService is like RegisterTestService but returns an
+// error rather than exiting if the service can't be registered.
+func TryRegisterTestService(s *wsrpc.Server, srv TestService) error {
+	return s.TryRegisterService(&TestServiceDescriptor, srv)
+}
+
+func TestExecuteHandler(srv interface{}, ctx context.Context, decode func(interface{}) error, interceptor wsrpc.UnaryServerInterceptor) (interface{}, error) {
 	in := &SimpleRequest{}
 	if err := decode(in); err != nil {
 		return nil, err
 	}
-	return srv.(TestService).Execute(ctx, in)
+	if interceptor == nil {
+		return srv.(TestService).Execute(ctx, in)
+	}
+	info := &wsrpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/plugin_test.Test/Execute",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TestService).Execute(ctx, req.(*SimpleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func TestDownstreamHandler(srv interface{}, stream wsrpc.ServerStream) error {
+	m := new(SimpleRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TestService).Downstream(m, &testDownstreamServer{stream})
+}
+
+type Test_DownstreamServer interface {
+	Send(*StreamMsg) error
+	wsrpc.ServerStream
+}
+
+type testDownstreamServer struct {
+	wsrpc.ServerStream
+}
+
+func (x *testDownstreamServer) Send(m *StreamMsg) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func TestUpstreamHandler(srv interface{}, stream wsrpc.ServerStream) error {
+	return srv.(TestService).Upstream(&testUpstreamServer{stream})
+}
+
+type Test_UpstreamServer interface {
+	SendAndClose(*SimpleResponse) error
+	Recv() (*StreamMsg, error)
+	wsrpc.ServerStream
+}
+
+type testUpstreamServer struct {
+	wsrpc.ServerStream
+}
+
+func (x *testUpstreamServer) SendAndClose(m *SimpleResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *testUpstreamServer) Recv() (*StreamMsg, error) {
+	m := new(StreamMsg)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func TestBidiHandler(srv interface{}, stream wsrpc.ServerStream) error {
+	return srv.(TestService).Bidi(&testBidiServer{stream})
+}
+
+type Test_BidiServer interface {
+	Send(*StreamMsg2) error
+	Recv() (*StreamMsg, error)
+	wsrpc.ServerStream
+}
+
+type testBidiServer struct {
+	wsrpc.ServerStream
+}
+
+func (x *testBidiServer) Send(m *StreamMsg2) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *testBidiServer) Recv() (*StreamMsg, error) {
+	m := new(StreamMsg)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func TestSequenceHandler(srv interface{}, ctx context.Context, decode func(interface{}) error, interceptor wsrpc.UnaryServerInterceptor) (interface{}, error) {
+	in := &SimpleRequest{}
+	if err := decode(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TestService).Sequence(ctx, in)
+	}
+	info := &wsrpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/plugin_test.Test/Sequence",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TestService).Sequence(ctx, req.(*SimpleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func TestAdminHandler(srv interface{}, ctx context.Context, decode func(interface{}) error, interceptor wsrpc.UnaryServerInterceptor) (interface{}, error) {
+	in := &SimpleRequest{}
+	if err := decode(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TestService).Admin(ctx, in)
+	}
+	info := &wsrpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/plugin_test.Test/Admin",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TestService).Admin(ctx, req.(*SimpleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
 var TestServiceDescriptor = wsrpc.ServiceDescriptor{
-	Name:        "plugin_test.Test",
-	HandlerType: (*TestService)(nil),
+	Name: "plugin_test.Test",
+	Type: (*TestService)(nil),
 	Methods: []wsrpc.MethodMap{
 		{
 			Name:    "Execute",
 			Handler: TestExecuteHandler,
 		},
+		{
+			Name:    "Sequence",
+			Handler: TestSequenceHandler,
+			Ordered: true,
+		},
+		{
+			Name:    "Admin",
+			Handler: TestAdminHandler,
+			Policy: wsrpc.Policy{
+				Roles:  []string{"admin"},
+				Scopes: []string{"write"},
+			},
+		},
+	},
+	Streams: []wsrpc.StreamMap{
+		{
+			Name:          "Downstream",
+			Handler:       TestDownstreamHandler,
+			ServerStreams: true,
+		},
+		{
+			Name:          "Upstream",
+			Handler:       TestUpstreamHandler,
+			ClientStreams: true,
+		},
+		{
+			Name:          "Bidi",
+			Handler:       TestBidiHandler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	About: "cmd/protoc-gen-gows/test/wsrpc.proto",
 }
-
-func init() { proto.RegisterFile("cmd/protoc-gen-gows/test/wsrpc.proto", fileDescriptor0) }
-
-var fileDescriptor0 = []byte{
-	// 136 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0x52, 0x49, 0xce, 0x4d, 0xd1,
-	0x2f, 0x28, 0xca, 0x2f, 0xc9, 0x4f, 0xd6, 0x4d, 0x4f, 0xcd, 0xd3, 0x4d, 0xcf, 0x2f, 0x2f, 0xd6,
-	0x2f, 0x49, 0x2d, 0x2e, 0xd1, 0x2f, 0x2f, 0x2e, 0x2a, 0x48, 0xd6, 0x03, 0x4b, 0x09, 0x71, 0x17,
-	0xe4, 0x94, 0xa6, 0x67, 0xe6, 0xc5, 0x83, 0x24, 0x94, 0xf8, 0xb9, 0x78, 0x83, 0x33, 0x73, 0x0b,
-	0x72, 0x52, 0x83, 0x52, 0x0b, 0x4b, 0x41, 0x02, 0x02, 0x5c, 0x7c, 0x30, 0x81, 0xe2, 0x82, 0xfc,
-	0xbc, 0xe2, 0x54, 0x23, 0x2f, 0x2e, 0x96, 0x90, 0xd4, 0xe2, 0x12, 0x21, 0x27, 0x2e, 0x76, 0xd7,
-	0x8a, 0xd4, 0xe4, 0xd2, 0x92, 0x54, 0x21, 0x29, 0x3d, 0x24, 0x33, 0xf4, 0x50, 0x0c, 0x90, 0x92,
-	0xc6, 0x2a, 0x07, 0x31, 0x2b, 0x89, 0x0d, 0xec, 0x04, 0x63, 0x40, 0x00, 0x00, 0x00, 0xff, 0xff,
-	0xf9, 0x69, 0xe7, 0xcb, 0xaa, 0x00, 0x00, 0x00,
-}
@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"path"
 	"strconv"
+	"strings"
 
+	"github.com/golang/protobuf/proto"
 	pb "github.com/golang/protobuf/protoc-gen-go/descriptor"
 	"github.com/golang/protobuf/protoc-gen-go/generator"
+	"github.com/toba/wsrpc"
 )
 
 // Paths for packages used by code generated in this file relative to the
@@ -123,18 +126,29 @@ func (ws *wsRPC) generateService(file *generator.FileDescriptor, service *pb.Ser
 	ws.P("s.RegisterService(&", descriptor, `, srv)`)
 	ws.P("}")
 	ws.P()
+	ws.P("// TryRegister", name, "Service is like Register", name, "Service but returns an")
+	ws.P("// error rather than exiting if the service can't be registered.")
+	ws.P("func TryRegister", name, "Service(s *", wsRpcPkg, ".Server, srv ", serviceType, ") error {")
+	ws.P("return s.TryRegisterService(&", descriptor, `, srv)`)
+	ws.P("}")
+	ws.P()
 
 	// Service endpoints.
 	var handlerNames []string
 	for _, method := range service.Method {
-		hname := ws.generateServiceMethod(name, fullName, method)
+		var hname string
+		if method.GetServerStreaming() || method.GetClientStreaming() {
+			hname = ws.generateStreamMethod(name, method)
+		} else {
+			hname = ws.generateServiceMethod(name, fullName, method)
+		}
 		handlerNames = append(handlerNames, hname)
 	}
 
 	// Service descriptor.
 	ws.P("var ", descriptor, " = ", wsRpcPkg, ".ServiceDescriptor {")
 	ws.P("Name: ", strconv.Quote(fullName), ",")
-	ws.P("HandlerType: (*", serviceType, ")(nil),")
+	ws.P("Type: (*", serviceType, ")(nil),")
 	ws.P("Methods: []", wsRpcPkg, ".MethodMap{")
 	for i, method := range service.Method {
 		if method.GetServerStreaming() || method.GetClientStreaming() {
@@ -143,6 +157,31 @@ func (ws *wsRPC) generateService(file *generator.FileDescriptor, service *pb.Ser
 		ws.P("{")
 		ws.P("Name: ", strconv.Quote(method.GetName()), ",")
 		ws.P("Handler: ", handlerNames[i], ",")
+		if ordered(method) {
+			ws.P("Ordered: true,")
+		}
+		ws.generatePolicy(method)
+		ws.P("},")
+	}
+	ws.P("},")
+	ws.P("Streams: []", wsRpcPkg, ".StreamMap{")
+	for i, method := range service.Method {
+		if !method.GetServerStreaming() && !method.GetClientStreaming() {
+			continue
+		}
+		ws.P("{")
+		ws.P("Name: ", strconv.Quote(method.GetName()), ",")
+		ws.P("Handler: ", handlerNames[i], ",")
+		if method.GetServerStreaming() {
+			ws.P("ServerStreams: true,")
+		}
+		if method.GetClientStreaming() {
+			ws.P("ClientStreams: true,")
+		}
+		if ordered(method) {
+			ws.P("Ordered: true,")
+		}
+		ws.generatePolicy(method)
 		ws.P("},")
 	}
 	ws.P("},")
@@ -151,25 +190,155 @@ func (ws *wsRPC) generateService(file *generator.FileDescriptor, service *pb.Ser
 	ws.P()
 }
 
+// ordered indicates whether the method has the wsrpc.ordered option.
+func ordered(method *pb.MethodDescriptorProto) bool {
+	if method.Options == nil {
+		return false
+	}
+	v, err := proto.GetExtension(method.Options, wsrpc.E_Ordered)
+	if err != nil {
+		return false
+	}
+	b, ok := v.(*bool)
+	return ok && *b
+}
+
+// stringsOption returns the values of a repeated string method option.
+func stringsOption(method *pb.MethodDescriptorProto, ext *proto.ExtensionDesc) []string {
+	if method.Options == nil {
+		return nil
+	}
+	v, err := proto.GetExtension(method.Options, ext)
+	if err != nil {
+		return nil
+	}
+	values, _ := v.([]string)
+	return values
+}
+
+// generatePolicy writes the Policy field of a method descriptor from the
+// wsrpc.roles and wsrpc.scopes options, if the method has them.
+func (ws *wsRPC) generatePolicy(method *pb.MethodDescriptorProto) {
+	roles := stringsOption(method, wsrpc.E_Roles)
+	scopes := stringsOption(method, wsrpc.E_Scopes)
+	if len(roles) == 0 && len(scopes) == 0 {
+		return
+	}
+	quote := func(values []string) string {
+		quoted := make([]string, len(values))
+		for i, v := range values {
+			quoted[i] = strconv.Quote(v)
+		}
+		return strings.Join(quoted, ", ")
+	}
+	ws.P("Policy: ", wsRpcPkg, ".Policy{")
+	if len(roles) > 0 {
+		ws.P("Roles: []string{", quote(roles), "},")
+	}
+	if len(scopes) > 0 {
+		ws.P("Scopes: []string{", quote(scopes), "},")
+	}
+	ws.P("},")
+}
+
 // generateServerSignature returns the server-side signature for a method.
 func (ws *wsRPC) generateServerSignature(servName string, method *pb.MethodDescriptorProto) string {
-	return generator.CamelCase(method.GetName()) +
-		"(" + contextPkg + ".Context" + ") " +
+	methodName := generator.CamelCase(method.GetName())
+	if method.GetClientStreaming() {
+		return methodName + "(" + servName + "_" + methodName + "Server) error"
+	}
+	if method.GetServerStreaming() {
+		return methodName +
+			"(*" + ws.typeName(method.GetInputType()) + ", " + servName + "_" + methodName + "Server) error"
+	}
+	return methodName +
+		"(" + contextPkg + ".Context, *" + ws.typeName(method.GetInputType()) + ") " +
 		"(*" + ws.typeName(method.GetOutputType()) + ", error)"
 }
 
-// generateServiceMethod creates Go code
+// generateServiceMethod creates Go code for a unary method handler, which
+// runs the method through the interceptor if there is one.
 func (ws *wsRPC) generateServiceMethod(serviceName, fullServName string, method *pb.MethodDescriptorProto) string {
 	methodName := generator.CamelCase(method.GetName())
 	fullName := fmt.Sprintf("%s%sHandler", serviceName, methodName)
 	inType := ws.typeName(method.GetInputType())
 
-	ws.P("func ", fullName, "(srv interface{}, ctx ", contextPkg, ".Context, decode func(interface{}) error) (interface{}, error) {")
+	ws.P("func ", fullName, "(srv interface{}, ctx ", contextPkg, ".Context, decode func(interface{}) error, interceptor ", wsRpcPkg, ".UnaryServerInterceptor) (interface{}, error) {")
 	ws.P("in := &", inType, "{}")
 	ws.P("if err := decode(in); err != nil { return nil, err }")
-	ws.P("return srv.(", serviceName, "Service).", methodName, "(ctx, in)")
+	ws.P("if interceptor == nil { return srv.(", serviceName, "Service).", methodName, "(ctx, in) }")
+	ws.P("info := &", wsRpcPkg, ".UnaryServerInfo{")
+	ws.P("Server: srv,")
+	ws.P("FullMethod: ", strconv.Quote(fmt.Sprintf("/%s/%s", fullServName, method.GetName())), ",")
+	ws.P("}")
+	ws.P("handler := func(ctx ", contextPkg, ".Context, req interface{}) (interface{}, error) {")
+	ws.P("return srv.(", serviceName, "Service).", methodName, "(ctx, req.(*", inType, "))")
+	ws.P("}")
+	ws.P("return interceptor(ctx, in, info, handler)")
+	ws.P("}")
+	ws.P()
+
+	return fullName
+}
+
+// generateStreamMethod creates Go code for a streaming method handler and the
+// typed stream passed to the service implementation.
+func (ws *wsRPC) generateStreamMethod(serviceName string, method *pb.MethodDescriptorProto) string {
+	methodName := generator.CamelCase(method.GetName())
+	fullName := fmt.Sprintf("%s%sHandler", serviceName, methodName)
+	streamType := unexport(serviceName) + methodName + "Server"
+	inType := ws.typeName(method.GetInputType())
+	outType := ws.typeName(method.GetOutputType())
+
+	ws.P("func ", fullName, "(srv interface{}, stream ", wsRpcPkg, ".ServerStream) error {")
+	if !method.GetClientStreaming() {
+		ws.P("m := new(", inType, ")")
+		ws.P("if err := stream.RecvMsg(m); err != nil { return err }")
+		ws.P("return srv.(", serviceName, "Service).", methodName, "(m, &", streamType, "{stream})")
+	} else {
+		ws.P("return srv.(", serviceName, "Service).", methodName, "(&", streamType, "{stream})")
+	}
+	ws.P("}")
+	ws.P()
+
+	ws.P("type ", serviceName, "_", methodName, "Server interface {")
+	if method.GetServerStreaming() {
+		ws.P("Send(*", outType, ") error")
+	} else {
+		ws.P("SendAndClose(*", outType, ") error")
+	}
+	if method.GetClientStreaming() {
+		ws.P("Recv() (*", inType, ", error)")
+	}
+	ws.P(wsRpcPkg, ".ServerStream")
+	ws.P("}")
+	ws.P()
+
+	ws.P("type ", streamType, " struct {")
+	ws.P(wsRpcPkg, ".ServerStream")
+	ws.P("}")
+	ws.P()
+
+	if method.GetServerStreaming() {
+		ws.P("func (x *", streamType, ") Send(m *", outType, ") error {")
+	} else {
+		ws.P("func (x *", streamType, ") SendAndClose(m *", outType, ") error {")
+	}
+	ws.P("return x.ServerStream.SendMsg(m)")
 	ws.P("}")
 	ws.P()
 
+	if method.GetClientStreaming() {
+		ws.P("func (x *", streamType, ") Recv() (*", inType, ", error) {")
+		ws.P("m := new(", inType, ")")
+		ws.P("if err := x.ServerStream.RecvMsg(m); err != nil { return nil, err }")
+		ws.P("return m, nil")
+		ws.P("}")
+		ws.P()
+	}
+
 	return fullName
 }
+
+// unexport lower-cases the first letter of a name.
+func unexport(s string) string { return strings.ToLower(s[:1]) + s[1:] }
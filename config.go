@@ -1,4 +1,207 @@
 package wsrpc
 
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Config holds the settings for a Server. Fields left at their zero value are
+// given a default when the server is created.
 type Config struct {
+	// Time allowed to write a message to a client. Default 10 seconds.
+	WriteWait time.Duration
+	// Time allowed to read the next pong message from a client. Default 60
+	// seconds.
+	PongWait time.Duration
+	// How often clients are pinged. Must be less than PongWait. Default nine
+	// tenths of PongWait.
+	PingPeriod time.Duration
+	// Largest message in bytes accepted from a client. A client sending more
+	// is disconnected. Default 64 KiB.
+	MaxMessageSize int64
+	// I/O buffer sizes in bytes for each connection. These don't limit the
+	// size of messages. Default 1024 bytes each.
+	ReadBufferSize  int
+	WriteBufferSize int
+	// Number of outbound messages that may be queued for each client before
+	// senders wait. Default 256.
+	SendQueueSize int
+	// Bytes of streamed messages a client is assumed to buffer unless it sets
+	// its own window. Default 65535, the same as HTTP/2.
+	InitialWindowSize uint32
+	// Bytes of messages a client may stream to a call before the handler
+	// reads them. A client sending more has the call ended with status
+	// ResourceExhausted. Default 1 MiB.
+	StreamBufferSize int
+
+	// Number of goroutines that run unary calls. Default 1024.
+	Workers int
+	// Number of calls that may wait for a worker before new calls are refused
+	// with status ResourceExhausted. Default 1024.
+	CallQueueSize int
+	// Number of calls a single client may have running at once. Further calls
+	// wait their turn so one client can't take every worker. Default 100, the
+	// usual HTTP/2 concurrent stream limit.
+	MaxClientCalls int
+	// Number of calls a single client may have waiting for one of its running
+	// calls to end before its new calls are refused with status
+	// ResourceExhausted. Default 100.
+	ClientQueueSize int
+	// Number of streaming calls that may be open at once across all clients
+	// before new streams are refused with status ResourceExhausted. Streams
+	// don't use the workers since they may stay open indefinitely.
+	// Default 10000.
+	MaxStreams int
+
+	// Origins allowed to connect, matched against the Origin header browsers
+	// send. An entry is a host, with a port if it isn't the default, such as
+	// "app.example.com" or "localhost:3000". It may be prefixed with a scheme,
+	// "https://app.example.com", to allow only that scheme and the host may be
+	// "*.example.com" to allow any subdomain. When empty, only an Origin
+	// matching the request Host is allowed.
+	AllowedOrigins []string
+	// Whether to allow requests with no Origin header. Browsers always send
+	// one so these come from other clients, such as native apps or servers,
+	// which aren't subject to cross-site attacks.
+	AllowMissingOrigin bool
+	// Custom check of whether a request may connect. When set it's used in
+	// place of AllowedOrigins and AllowMissingOrigin.
+	CheckOrigin func(r *http.Request) bool
+	// Checks the credentials of each request to connect, refusing it with 401
+	// or 403 before the WebSocket upgrade. The identity returned is attached
+	// to the Client and available to handlers from IdentityFromContext.
+	Authenticate Authenticator
+	// Checks a token sent by a connected client to replace the one it
+	// authenticated with. Without it the upgrade request is passed to
+	// Authenticate again with the new token as its bearer token and its
+	// cookies removed.
+	Refresh Refresher
+	// Who may call each method, keyed by full method name in the form
+	// <package>.<service>/<method>, optionally with a leading slash as gRPC
+	// writes it. An entry replaces the Policy registered
+	// with the method, if any, so rules can be declared in Go as well as with
+	// proto options. Calls that fail the policy get status PermissionDenied.
+	Policies map[string]Policy
+
+	// Interceptors run around every unary call in order, the first being
+	// outermost, for concerns such as logging, auth and metrics.
+	UnaryInterceptors []UnaryServerInterceptor
+	// Interceptors run around every streaming call in order, the first being
+	// outermost.
+	StreamInterceptors []StreamServerInterceptor
+
+	// Called when a call panics, after the panic and stack are logged, such as
+	// to report it to an error tracker. The context is the call's. The client
+	// is sent status Internal either way.
+	PanicHandler func(ctx context.Context, method string, p interface{}, stack []byte)
+
+	// Destination for log events, which carry fields such as the client
+	// address, method, call ID and duration. Calls are logged at level Debug,
+	// problems with a single client at Warn and server faults at Error.
+	// Default slog.Default().
+	Logger *slog.Logger
+}
+
+const (
+	defaultWriteWait         = 10 * time.Second
+	defaultPongWait          = 60 * time.Second
+	defaultMaxMessageSize    = 64 * 1024
+	defaultBufferSize        = 1024
+	defaultSendQueueSize     = 256
+	defaultInitialWindowSize = 65535
+	defaultStreamBufferSize  = 1024 * 1024
+	defaultWorkers           = 1024
+	defaultCallQueueSize     = 1024
+	defaultMaxClientCalls    = 100
+	defaultClientQueueSize   = 100
+	defaultMaxStreams        = 10000
+)
+
+// Validate reports whether the settings can be used. Zero values are valid
+// since they're replaced by defaults.
+func (c Config) Validate() error {
+	c = c.withDefaults()
+
+	switch {
+	case c.WriteWait < 0, c.PongWait < 0, c.PingPeriod < 0:
+		return errors.New("wsrpc: Config durations must not be negative")
+	case c.PingPeriod >= c.PongWait:
+		return errors.New("wsrpc: Config.PingPeriod must be less than PongWait")
+	case c.MaxMessageSize < 0:
+		return errors.New("wsrpc: Config.MaxMessageSize must not be negative")
+	case c.ReadBufferSize < 0, c.WriteBufferSize < 0:
+		return errors.New("wsrpc: Config buffer sizes must not be negative")
+	case c.StreamBufferSize < 0:
+		return errors.New("wsrpc: Config.StreamBufferSize must not be negative")
+	case c.SendQueueSize < 0:
+		return errors.New("wsrpc: Config.SendQueueSize must not be negative")
+	case c.Workers < 0, c.MaxClientCalls < 0, c.MaxStreams < 0:
+		return errors.New("wsrpc: Config call limits must not be negative")
+	case c.CallQueueSize < 0, c.ClientQueueSize < 0:
+		return errors.New("wsrpc: Config call queue sizes must not be negative")
+	}
+	for _, o := range c.AllowedOrigins {
+		if o == "" || strings.HasSuffix(o, "://") {
+			return errors.New("wsrpc: Config.AllowedOrigins entries must include a host")
+		}
+	}
+	if _, err := normalizePolicies(c.Policies); err != nil {
+		return err
+	}
+	return nil
+}
+
+// withDefaults returns a copy of the settings with defaults in place of zero
+// values.
+func (c Config) withDefaults() Config {
+	if c.WriteWait == 0 {
+		c.WriteWait = defaultWriteWait
+	}
+	if c.PongWait == 0 {
+		c.PongWait = defaultPongWait
+	}
+	if c.PingPeriod == 0 {
+		c.PingPeriod = (c.PongWait * 9) / 10
+	}
+	if c.MaxMessageSize == 0 {
+		c.MaxMessageSize = defaultMaxMessageSize
+	}
+	if c.ReadBufferSize == 0 {
+		c.ReadBufferSize = defaultBufferSize
+	}
+	if c.WriteBufferSize == 0 {
+		c.WriteBufferSize = defaultBufferSize
+	}
+	if c.SendQueueSize == 0 {
+		c.SendQueueSize = defaultSendQueueSize
+	}
+	if c.InitialWindowSize == 0 {
+		c.InitialWindowSize = defaultInitialWindowSize
+	}
+	if c.StreamBufferSize == 0 {
+		c.StreamBufferSize = defaultStreamBufferSize
+	}
+	if c.Workers == 0 {
+		c.Workers = defaultWorkers
+	}
+	if c.CallQueueSize == 0 {
+		c.CallQueueSize = defaultCallQueueSize
+	}
+	if c.MaxClientCalls == 0 {
+		c.MaxClientCalls = defaultMaxClientCalls
+	}
+	if c.ClientQueueSize == 0 {
+		c.ClientQueueSize = defaultClientQueueSize
+	}
+	if c.MaxStreams == 0 {
+		c.MaxStreams = defaultMaxStreams
+	}
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
+	return c
 }
@@ -0,0 +1,36 @@
+package wsrpc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/toba/wsrpc"
+)
+
+func TestConfigValidate(t *testing.T) {
+	assert.NoError(t, wsrpc.Config{}.Validate())
+	assert.NoError(t, wsrpc.Config{PongWait: time.Second, PingPeriod: 500 * time.Millisecond}.Validate())
+
+	assert.Error(t, wsrpc.Config{PongWait: time.Second, PingPeriod: time.Second}.Validate())
+	assert.Error(t, wsrpc.Config{PingPeriod: 2 * time.Minute}.Validate())
+	assert.Error(t, wsrpc.Config{WriteWait: -time.Second}.Validate())
+	assert.Error(t, wsrpc.Config{MaxMessageSize: -1}.Validate())
+	assert.Error(t, wsrpc.Config{ReadBufferSize: -1}.Validate())
+	assert.Error(t, wsrpc.Config{SendQueueSize: -1}.Validate())
+	assert.Error(t, wsrpc.Config{StreamBufferSize: -1}.Validate())
+	assert.Error(t, wsrpc.Config{Workers: -1}.Validate())
+	assert.Error(t, wsrpc.Config{MaxStreams: -1}.Validate())
+	assert.Error(t, wsrpc.Config{ClientQueueSize: -1}.Validate())
+	assert.Error(t, wsrpc.Config{AllowedOrigins: []string{""}}.Validate())
+	assert.Error(t, wsrpc.Config{AllowedOrigins: []string{"https://"}}.Validate())
+	assert.Error(t, wsrpc.Config{Policies: map[string]wsrpc.Policy{"Execute": {}}}.Validate())
+	assert.Error(t, wsrpc.Config{Policies: map[string]wsrpc.Policy{
+		"pkg.Svc/Method":  {Roles: []string{"a"}},
+		"/pkg.Svc/Method": {Roles: []string{"b"}},
+	}}.Validate())
+}
+
+func TestNewServerInvalidConfig(t *testing.T) {
+	assert.Panics(t, func() { wsrpc.NewServer(wsrpc.Config{SendQueueSize: -1}) })
+}
@@ -0,0 +1,173 @@
+package wsrpc
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// work runs calls from the server queue until the server stops.
+func (s *Server) work() {
+	for {
+		select {
+		case req := <-s.request:
+			s.handle(req)
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// enqueue queues a call for the workers, refusing it if the queue is full
+// rather than blocking the client's readPump. Streaming calls may stay open
+// indefinitely so they run in their own goroutine instead, up to
+// Config.MaxStreams at once, leaving the workers to unary calls.
+func (s *Server) enqueue(req *Request) {
+	if req.streaming {
+		select {
+		case s.streams <- struct{}{}:
+			go func() {
+				defer func() { <-s.streams }()
+				s.handle(req)
+			}()
+		default:
+			s.reject(req, status.Error(codes.ResourceExhausted, "wsrpc: too many streams open"))
+		}
+		return
+	}
+	select {
+	case s.request <- req:
+	default:
+		s.reject(req, status.Error(codes.ResourceExhausted, "wsrpc: the server is too busy"))
+	}
+}
+
+// reject ends a call that was given one of its client's slots but won't be
+// run, passing the slot on.
+func (s *Server) reject(req *Request, err error) {
+	s.refuse(req, err)
+	req.Client.release(req)
+	req.Client.next()
+}
+
+// refuse ends a call that was started but won't be run.
+func (s *Server) refuse(req *Request, err error) {
+	req.Client.closeStream(req.stream)
+	req.stream.cancel()
+	s.finish(req.Client, &Reply{Id: req.ID}, err)
+	s.calls.Done()
+}
+
+// dispatch hands a call to the server workers unless the client already has
+// Config.MaxClientCalls running, in which case it waits for one of them to
+// end. A call is refused if the client has too many waiting.
+func (c *Client) dispatch(req *Request) {
+	config := c.server.config
+
+	c.mu.Lock()
+	if c.running < config.MaxClientCalls {
+		c.running++
+		c.mu.Unlock()
+		c.server.enqueue(req)
+		return
+	}
+	full := len(c.pending) >= config.ClientQueueSize
+	if !full {
+		c.pending = append(c.pending, req)
+	}
+	c.mu.Unlock()
+
+	if full {
+		c.server.refuse(req, status.Error(codes.ResourceExhausted, "wsrpc: too many calls in progress"))
+		c.release(req)
+	}
+}
+
+// next is called when one of the client's calls ends, handing its slot to the
+// oldest waiting call if there is one.
+func (c *Client) next() {
+	c.mu.Lock()
+	if len(c.pending) == 0 {
+		c.running--
+		c.mu.Unlock()
+		return
+	}
+	req := c.pending[0]
+	c.pending = c.pending[1:]
+	c.mu.Unlock()
+
+	c.server.enqueue(req)
+}
+
+// describe indicates whether the named method has the Ordered option and
+// whether it's a streaming method.
+func (s *Server) describe(name string) (ordered, streaming bool) {
+	srv, method, err := s.lookup(name)
+	if err != nil {
+		return false, false
+	}
+	if md, ok := srv.methods[method]; ok {
+		return md.Ordered, false
+	}
+	if sd, ok := srv.streams[method]; ok {
+		return sd.Ordered, true
+	}
+	return false, false
+}
+
+// order holds back a call to an ordered method while an earlier call from the
+// client with the same ordering key is still running. Other calls are
+// dispatched straight away.
+func (c *Client) order(req *Request) {
+	ordered, streaming := c.server.describe(req.Method)
+	req.streaming = streaming
+	if !ordered {
+		c.dispatch(req)
+		return
+	}
+	key := req.call.OrderingKey
+
+	c.mu.Lock()
+	waiting, busy := c.sequences[key]
+	if !busy {
+		c.sequences[key] = nil
+		req.ordered = true
+		c.mu.Unlock()
+		c.dispatch(req)
+		return
+	}
+	full := c.sequenced >= c.server.config.ClientQueueSize
+	if !full {
+		c.sequences[key] = append(waiting, req)
+		c.sequenced++
+	}
+	c.mu.Unlock()
+
+	if full {
+		c.server.refuse(req, status.Error(codes.ResourceExhausted, "wsrpc: too many ordered calls waiting"))
+	}
+}
+
+// release is called when a call ends. If it held an ordering key, the next
+// call waiting for the key is dispatched.
+func (c *Client) release(req *Request) {
+	c.mu.Lock()
+	if !req.ordered {
+		c.mu.Unlock()
+		return
+	}
+	req.ordered = false
+	key := req.call.OrderingKey
+	waiting := c.sequences[key]
+	if len(waiting) == 0 {
+		delete(c.sequences, key)
+		c.mu.Unlock()
+		return
+	}
+	next := waiting[0]
+	next.ordered = true
+	c.sequences[key] = waiting[1:]
+	c.sequenced--
+	c.mu.Unlock()
+
+	c.dispatch(next)
+}
@@ -0,0 +1,197 @@
+package wsrpc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/toba/wsrpc"
+	plugin "github.com/toba/wsrpc/cmd/protoc-gen-gows/test"
+	"google.golang.org/grpc/codes"
+)
+
+// blockingService blocks each Execute with the text "block" until release is
+// closed, signalling started as it begins.
+func blockingService(t *testing.T, started chan<- string, release <-chan struct{}) *mockService {
+	return &mockService{
+		t: t,
+		execute: func(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error) {
+			started <- in.Text
+			if in.Text == "block" {
+				<-release
+			}
+			return &plugin.SimpleResponse{Text: in.Text}, nil
+		},
+	}
+}
+
+func TestWorkerQueue(t *testing.T) {
+	started := make(chan string, 3)
+	release := make(chan struct{})
+	addr := serve(t, serverWith(t, wsrpc.Config{Workers: 1, CallQueueSize: 1}, blockingService(t, started, release)))
+	a, b, other := dialURL(t, addr), dialURL(t, addr), dialURL(t, addr)
+
+	call(t, a, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "block"})
+	assert.Equal(t, "block", <-started)
+
+	// the only worker is busy so whichever of these arrives first waits in
+	// the queue and, with the queue then full, the other is refused
+	replies := make(chan *wsrpc.Reply, 2)
+	for _, conn := range []*websocket.Conn{b, other} {
+		call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "queued"})
+		go func(conn *websocket.Conn) { replies <- reply(t, conn) }(conn)
+	}
+	assert.Equal(t, int32(codes.ResourceExhausted), (<-replies).Status.GetCode())
+
+	close(release)
+	assert.Equal(t, int32(codes.OK), reply(t, a).Status.GetCode())
+	assert.Equal(t, int32(codes.OK), (<-replies).Status.GetCode())
+	assert.Equal(t, "queued", <-started)
+}
+
+func TestClientCallLimit(t *testing.T) {
+	started := make(chan string, 3)
+	release := make(chan struct{})
+	addr := serve(t, serverWith(t, wsrpc.Config{MaxClientCalls: 1, ClientQueueSize: 1}, blockingService(t, started, release)))
+	conn, other := dialURL(t, addr), dialURL(t, addr)
+
+	call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "block"})
+	assert.Equal(t, "block", <-started)
+	call(t, conn, 2, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "waiting"})
+	call(t, conn, 3, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "refused"})
+
+	r := reply(t, conn)
+	assert.Equal(t, uint32(3), r.Id)
+	assert.Equal(t, int32(codes.ResourceExhausted), r.Status.Code)
+
+	// other clients aren't held up
+	call(t, other, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "other"})
+	assert.Equal(t, int32(codes.OK), reply(t, other).Status.GetCode())
+	assert.Equal(t, "other", <-started)
+
+	close(release)
+	assert.Equal(t, uint32(1), reply(t, conn).Id)
+	r = reply(t, conn)
+	assert.Equal(t, uint32(2), r.Id)
+	assert.Equal(t, int32(codes.OK), r.Status.GetCode())
+}
+
+func TestStreamsOutsideWorkers(t *testing.T) {
+	addr := serve(t, serverWith(t, wsrpc.Config{Workers: 1, MaxStreams: 1}, &mockService{t: t}))
+	conn, other := dialURL(t, addr), dialURL(t, addr)
+
+	open(t, conn, 1, "plugin_test.Test/Bidi")
+	send(t, conn, 1, &plugin.StreamMsg{Text: "one"})
+	assert.Equal(t, uint32(1), reply(t, conn).Id)
+
+	// the open stream doesn't hold the only worker
+	call(t, other, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+	assert.Equal(t, int32(codes.OK), reply(t, other).Status.GetCode())
+
+	// but it does use the only stream slot
+	open(t, other, 2, "plugin_test.Test/Bidi")
+	r := reply(t, other)
+	assert.Equal(t, uint32(2), r.Id)
+	assert.Equal(t, int32(codes.ResourceExhausted), r.Status.GetCode())
+
+	closeSend(t, conn, 1)
+	assert.Equal(t, int32(codes.OK), reply(t, conn).Status.GetCode())
+}
+
+func TestBroadcastWhileBusy(t *testing.T) {
+	started := make(chan string, 1)
+	release := make(chan struct{})
+	defer close(release)
+
+	rpc := serverWith(t, wsrpc.Config{Workers: 1}, blockingService(t, started, release))
+	conn := dialURL(t, serve(t, rpc))
+
+	call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "block"})
+	<-started
+
+	done := make(chan struct{})
+	go func() {
+		rpc.Broadcast([]byte("news"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("broadcast blocked by a busy worker")
+	}
+	r := reply(t, conn)
+	assert.Equal(t, "news", string(r.Broadcast))
+	assert.Zero(t, r.Id)
+	assert.Nil(t, r.Status)
+}
+
+// sequenceService blocks each Sequence call with the text "block" until
+// release is closed, signalling started as each call begins.
+func sequenceService(t *testing.T, started chan<- string, release <-chan struct{}) *mockService {
+	return &mockService{
+		t: t,
+		sequence: func(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error) {
+			started <- in.Text
+			if in.Text == "block" {
+				<-release
+			}
+			return &plugin.SimpleResponse{Text: in.Text}, nil
+		},
+	}
+}
+
+func sequence(t *testing.T, conn *websocket.Conn, id uint32, key, text string) {
+	payload, err := proto.Marshal(&plugin.SimpleRequest{Text: text})
+	assert.NoError(t, err)
+	write(t, conn, &wsrpc.Call{Id: id, Method: "plugin_test.Test/Sequence", Payload: payload, OrderingKey: key})
+}
+
+func TestOrderedCalls(t *testing.T) {
+	started := make(chan string, 4)
+	release := make(chan struct{})
+	conn := connect(t, mockServerWith(sequenceService(t, started, release)))
+	defer conn.Close()
+
+	sequence(t, conn, 1, "", "block")
+	assert.Equal(t, "block", <-started)
+	sequence(t, conn, 2, "", "next")
+
+	// unordered methods aren't held up
+	call(t, conn, 3, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+	assert.Equal(t, uint32(3), reply(t, conn).Id)
+
+	select {
+	case text := <-started:
+		t.Fatalf("%q started before the call ahead of it ended", text)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.Equal(t, uint32(1), reply(t, conn).Id)
+	assert.Equal(t, "next", <-started)
+	assert.Equal(t, uint32(2), reply(t, conn).Id)
+}
+
+func TestOrderingKeys(t *testing.T) {
+	started := make(chan string, 4)
+	release := make(chan struct{})
+	conn := connect(t, mockServerWith(sequenceService(t, started, release)))
+	defer conn.Close()
+
+	sequence(t, conn, 1, "a", "block")
+	assert.Equal(t, "block", <-started)
+	sequence(t, conn, 2, "a", "a")
+	sequence(t, conn, 3, "b", "b")
+
+	// only calls with the same key wait
+	assert.Equal(t, "b", <-started)
+	assert.Equal(t, uint32(3), reply(t, conn).Id)
+
+	close(release)
+	assert.Equal(t, uint32(1), reply(t, conn).Id)
+	assert.Equal(t, "a", <-started)
+	assert.Equal(t, uint32(2), reply(t, conn).Id)
+}
@@ -0,0 +1,488 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: envelope.proto
+
+package wsrpc
+
+import (
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	status "google.golang.org/genproto/googleapis/rpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+// Call is the envelope for every message a browser sends to the server. It
+// takes the place of the HTTP/2 headers gRPC uses to identify the method.
+//
+// The Call that starts an RPC names the method. For unary and server-streaming
+// methods it also carries the request message. For client-streaming and
+// bidirectional methods it carries no message; each message follows in its
+// own Call with the same ID and no method, and a Call with end_stream set
+// half-closes the stream.
+//
+// Messages the server streams are flow controlled like HTTP/2 DATA frames.
+// Each stream has a window of bytes the client is willing to buffer and the
+// server waits when it's used up until the client grants more with a Call
+// setting window.
+// Messages the client streams are not flow controlled. The server buffers a
+// limited amount for each call and ends the call with status ResourceExhausted
+// if the client gets too far ahead of the handler.
+//
+// A connection authenticated with a token that expires is closed with code
+// 4001 when it does unless the client sends a Call with a refreshed
+// access_token before then.
+type Call struct {
+	// Fully qualified method name in the form <package>.<service>/<method>. Only
+	// set on the Call that starts an RPC.
+	Method string `protobuf:"bytes,1,opt,name=method,proto3" json:"method,omitempty"`
+	// Encoded request message.
+	Payload []byte `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+	// Client assigned ID echoed in every Reply for the call so responses can be
+	// matched to requests in any order.
+	Id uint32 `protobuf:"varint,3,opt,name=id,proto3" json:"id,omitempty"`
+	// Set when the client will send no more messages on a stream. A Call with
+	// end_stream set carries no message.
+	EndStream bool `protobuf:"varint,4,opt,name=end_stream,json=endStream,proto3" json:"end_stream,omitempty"`
+	// On the Call that starts an RPC, the initial window in bytes for messages
+	// streamed by the server, in place of the default. On later Calls, bytes to
+	// add to the window like an HTTP/2 WINDOW_UPDATE. A later Call with window
+	// set carries no message.
+	Window uint32 `protobuf:"varint,5,opt,name=window,proto3" json:"window,omitempty"`
+	// Milliseconds the client will wait for the call to finish. Only read from
+	// the Call that starts an RPC; zero means no deadline.
+	TimeoutMs uint32 `protobuf:"varint,6,opt,name=timeout_ms,json=timeoutMs,proto3" json:"timeout_ms,omitempty"`
+	// Set when the client abandons a call, like an HTTP/2 RST_STREAM. The
+	// server cancels the call context and sends no further replies for it. A
+	// Call with cancel set carries no message.
+	Cancel bool `protobuf:"varint,7,opt,name=cancel,proto3" json:"cancel,omitempty"`
+	// Request metadata such as trace IDs or locale. Only read from the Call that
+	// starts an RPC.
+	Metadata []*Metadata `protobuf:"bytes,8,rep,name=metadata,proto3" json:"metadata,omitempty"`
+	// For methods with the wsrpc.ordered option, calls from the client with the
+	// same key run one at a time in the order sent. Calls without a key are
+	// ordered with each other. Only read from the Call that starts an RPC.
+	OrderingKey string `protobuf:"bytes,9,opt,name=ordering_key,json=orderingKey,proto3" json:"ordering_key,omitempty"`
+	// A refreshed access token for the connection, replacing the one it was
+	// authenticated with. The server checks it with its authenticator and
+	// answers with a Reply having the same ID and a status of OK or the
+	// reason it was refused. A Call with access_token set is a control
+	// message; it has no method or payload and its ID should not be in use.
+	AccessToken          string   `protobuf:"bytes,10,opt,name=access_token,json=accessToken,proto3" json:"access_token,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Call) Reset()         { *m = Call{} }
+func (m *Call) String() string { return proto.CompactTextString(m) }
+func (*Call) ProtoMessage()    {}
+func (*Call) Descriptor() ([]byte, []int) {
+	return fileDescriptor_ee266e8c558e9dc5, []int{0}
+}
+
+func (m *Call) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Call.Unmarshal(m, b)
+}
+func (m *Call) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Call.Marshal(b, m, deterministic)
+}
+func (m *Call) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Call.Merge(m, src)
+}
+func (m *Call) XXX_Size() int {
+	return xxx_messageInfo_Call.Size(m)
+}
+func (m *Call) XXX_DiscardUnknown() {
+	xxx_messageInfo_Call.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Call proto.InternalMessageInfo
+
+func (m *Call) GetMethod() string {
+	if m != nil {
+		return m.Method
+	}
+	return ""
+}
+
+func (m *Call) GetPayload() []byte {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+func (m *Call) GetId() uint32 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *Call) GetEndStream() bool {
+	if m != nil {
+		return m.EndStream
+	}
+	return false
+}
+
+func (m *Call) GetWindow() uint32 {
+	if m != nil {
+		return m.Window
+	}
+	return 0
+}
+
+func (m *Call) GetTimeoutMs() uint32 {
+	if m != nil {
+		return m.TimeoutMs
+	}
+	return 0
+}
+
+func (m *Call) GetCancel() bool {
+	if m != nil {
+		return m.Cancel
+	}
+	return false
+}
+
+func (m *Call) GetMetadata() []*Metadata {
+	if m != nil {
+		return m.Metadata
+	}
+	return nil
+}
+
+func (m *Call) GetOrderingKey() string {
+	if m != nil {
+		return m.OrderingKey
+	}
+	return ""
+}
+
+func (m *Call) GetAccessToken() string {
+	if m != nil {
+		return m.AccessToken
+	}
+	return ""
+}
+
+// Reply is the envelope for every message the server sends to a browser. A
+// unary call gets a single Reply with both payload and status. Streaming calls
+// get a Reply with only a payload for each message followed by a Reply with
+// only a status to end the stream.
+//
+// Response header metadata, if any, comes in its own Reply before the first
+// message of a stream, or with the last Reply if the call ends before then. A
+// Reply with a header but no status carries no message.
+//
+// A Reply with go_away, push or broadcast set isn't for a call. It has no ID,
+// status or payload of its own.
+type Reply struct {
+	// Encoded response message.
+	Payload []byte `protobuf:"bytes,1,opt,name=payload,proto3" json:"payload,omitempty"`
+	// ID of the Call this replies to.
+	Id uint32 `protobuf:"varint,2,opt,name=id,proto3" json:"id,omitempty"`
+	// Outcome of the call, set only on the last reply for a call and so also
+	// marking the end of a stream. The code is one of google.golang.org/grpc/codes
+	// and is OK when the call succeeded.
+	Status *status.Status `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	// Response header metadata.
+	Header []*Metadata `protobuf:"bytes,4,rep,name=header,proto3" json:"header,omitempty"`
+	// Response trailer metadata, only sent with the status.
+	Trailer []*Metadata `protobuf:"bytes,5,rep,name=trailer,proto3" json:"trailer,omitempty"`
+	// Set when the server is about to shut down.
+	GoAway *GoAway `protobuf:"bytes,6,opt,name=go_away,json=goAway,proto3" json:"go_away,omitempty"`
+	// A message the server sent on its own rather than in reply to a call.
+	Push *Push `protobuf:"bytes,7,opt,name=push,proto3" json:"push,omitempty"`
+	// Bytes passed to Server.Broadcast for every connected client, in whatever
+	// encoding the application chose.
+	Broadcast            []byte   `protobuf:"bytes,8,opt,name=broadcast,proto3" json:"broadcast,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Reply) Reset()         { *m = Reply{} }
+func (m *Reply) String() string { return proto.CompactTextString(m) }
+func (*Reply) ProtoMessage()    {}
+func (*Reply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_ee266e8c558e9dc5, []int{1}
+}
+
+func (m *Reply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Reply.Unmarshal(m, b)
+}
+func (m *Reply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Reply.Marshal(b, m, deterministic)
+}
+func (m *Reply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Reply.Merge(m, src)
+}
+func (m *Reply) XXX_Size() int {
+	return xxx_messageInfo_Reply.Size(m)
+}
+func (m *Reply) XXX_DiscardUnknown() {
+	xxx_messageInfo_Reply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Reply proto.InternalMessageInfo
+
+func (m *Reply) GetPayload() []byte {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+func (m *Reply) GetId() uint32 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *Reply) GetStatus() *status.Status {
+	if m != nil {
+		return m.Status
+	}
+	return nil
+}
+
+func (m *Reply) GetHeader() []*Metadata {
+	if m != nil {
+		return m.Header
+	}
+	return nil
+}
+
+func (m *Reply) GetTrailer() []*Metadata {
+	if m != nil {
+		return m.Trailer
+	}
+	return nil
+}
+
+func (m *Reply) GetGoAway() *GoAway {
+	if m != nil {
+		return m.GoAway
+	}
+	return nil
+}
+
+func (m *Reply) GetPush() *Push {
+	if m != nil {
+		return m.Push
+	}
+	return nil
+}
+
+func (m *Reply) GetBroadcast() []byte {
+	if m != nil {
+		return m.Broadcast
+	}
+	return nil
+}
+
+// Push is a message the server sends to a client unprompted, such as a
+// notification. The client should decode the payload as the named type and
+// pass it to any listeners registered for that type.
+type Push struct {
+	// Fully qualified name of the message type, such as
+	// "chat.MessagePosted".
+	Type string `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	// Encoded message.
+	Payload              []byte   `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Push) Reset()         { *m = Push{} }
+func (m *Push) String() string { return proto.CompactTextString(m) }
+func (*Push) ProtoMessage()    {}
+func (*Push) Descriptor() ([]byte, []int) {
+	return fileDescriptor_ee266e8c558e9dc5, []int{2}
+}
+
+func (m *Push) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Push.Unmarshal(m, b)
+}
+func (m *Push) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Push.Marshal(b, m, deterministic)
+}
+func (m *Push) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Push.Merge(m, src)
+}
+func (m *Push) XXX_Size() int {
+	return xxx_messageInfo_Push.Size(m)
+}
+func (m *Push) XXX_DiscardUnknown() {
+	xxx_messageInfo_Push.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Push proto.InternalMessageInfo
+
+func (m *Push) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *Push) GetPayload() []byte {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+// GoAway tells a client the server is draining, like an HTTP/2 GOAWAY frame.
+// The server refuses new calls on the connection with status Unavailable but
+// finishes those in progress. The client should open a new connection, which a
+// load balancer will route to another server, for new calls and close this one
+// once its calls have ended. The server closes the connection itself when it
+// stops.
+type GoAway struct {
+	// Why the server is draining, for logs.
+	Reason               string   `protobuf:"bytes,1,opt,name=reason,proto3" json:"reason,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GoAway) Reset()         { *m = GoAway{} }
+func (m *GoAway) String() string { return proto.CompactTextString(m) }
+func (*GoAway) ProtoMessage()    {}
+func (*GoAway) Descriptor() ([]byte, []int) {
+	return fileDescriptor_ee266e8c558e9dc5, []int{3}
+}
+
+func (m *GoAway) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GoAway.Unmarshal(m, b)
+}
+func (m *GoAway) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GoAway.Marshal(b, m, deterministic)
+}
+func (m *GoAway) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GoAway.Merge(m, src)
+}
+func (m *GoAway) XXX_Size() int {
+	return xxx_messageInfo_GoAway.Size(m)
+}
+func (m *GoAway) XXX_DiscardUnknown() {
+	xxx_messageInfo_GoAway.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GoAway proto.InternalMessageInfo
+
+func (m *GoAway) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
+// Metadata is a key with one or more values, equivalent to an HTTP/2 header in
+// gRPC. Keys are case insensitive.
+type Metadata struct {
+	Key                  string   `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Values               []string `protobuf:"bytes,2,rep,name=values,proto3" json:"values,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Metadata) Reset()         { *m = Metadata{} }
+func (m *Metadata) String() string { return proto.CompactTextString(m) }
+func (*Metadata) ProtoMessage()    {}
+func (*Metadata) Descriptor() ([]byte, []int) {
+	return fileDescriptor_ee266e8c558e9dc5, []int{4}
+}
+
+func (m *Metadata) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Metadata.Unmarshal(m, b)
+}
+func (m *Metadata) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Metadata.Marshal(b, m, deterministic)
+}
+func (m *Metadata) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Metadata.Merge(m, src)
+}
+func (m *Metadata) XXX_Size() int {
+	return xxx_messageInfo_Metadata.Size(m)
+}
+func (m *Metadata) XXX_DiscardUnknown() {
+	xxx_messageInfo_Metadata.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Metadata proto.InternalMessageInfo
+
+func (m *Metadata) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *Metadata) GetValues() []string {
+	if m != nil {
+		return m.Values
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*Call)(nil), "wsrpc.Call")
+	proto.RegisterType((*Reply)(nil), "wsrpc.Reply")
+	proto.RegisterType((*Push)(nil), "wsrpc.Push")
+	proto.RegisterType((*GoAway)(nil), "wsrpc.GoAway")
+	proto.RegisterType((*Metadata)(nil), "wsrpc.Metadata")
+}
+
+func init() { proto.RegisterFile("envelope.proto", fileDescriptor_ee266e8c558e9dc5) }
+
+var fileDescriptor_ee266e8c558e9dc5 = []byte{
+	// 474 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x52, 0xc1, 0x8e, 0xd3, 0x30,
+	0x10, 0x55, 0xd2, 0x34, 0x6d, 0x27, 0xbb, 0x0b, 0xf2, 0x01, 0x2c, 0x60, 0x45, 0xe8, 0x01, 0x0a,
+	0x48, 0xa9, 0x54, 0xf6, 0xc6, 0x09, 0x38, 0x70, 0x40, 0x2b, 0xa1, 0x2c, 0x27, 0x2e, 0xd5, 0x34,
+	0x1e, 0xa5, 0xd1, 0x26, 0x71, 0x64, 0x3b, 0x5b, 0xe5, 0x4f, 0xf8, 0x05, 0xfe, 0x12, 0xd9, 0x71,
+	0x16, 0x0e, 0xcb, 0x25, 0xf1, 0xbc, 0xf7, 0x26, 0xf1, 0x7b, 0x33, 0x70, 0x41, 0xed, 0x1d, 0xd5,
+	0xb2, 0xa3, 0xac, 0x53, 0xd2, 0x48, 0x36, 0x3f, 0x69, 0xd5, 0x15, 0xcf, 0x9e, 0x96, 0x52, 0x96,
+	0x35, 0x6d, 0x55, 0x57, 0x6c, 0xb5, 0x41, 0xd3, 0xeb, 0x91, 0x5f, 0xff, 0x0e, 0x21, 0xfa, 0x82,
+	0x75, 0xcd, 0x9e, 0x40, 0xdc, 0x90, 0x39, 0x4a, 0xc1, 0x83, 0x34, 0xd8, 0xac, 0x72, 0x5f, 0x31,
+	0x0e, 0x8b, 0x0e, 0x87, 0x5a, 0xa2, 0xe0, 0x61, 0x1a, 0x6c, 0xce, 0xf2, 0xa9, 0x64, 0x17, 0x10,
+	0x56, 0x82, 0xcf, 0xd2, 0x60, 0x73, 0x9e, 0x87, 0x95, 0x60, 0x97, 0x00, 0xd4, 0x8a, 0xbd, 0x36,
+	0x8a, 0xb0, 0xe1, 0x51, 0x1a, 0x6c, 0x96, 0xf9, 0x8a, 0x5a, 0x71, 0xe3, 0x00, 0xfb, 0x83, 0x53,
+	0xd5, 0x0a, 0x79, 0xe2, 0x73, 0xd7, 0xe2, 0x2b, 0xdb, 0x66, 0xaa, 0x86, 0x64, 0x6f, 0xf6, 0x8d,
+	0xe6, 0xb1, 0xe3, 0x56, 0x1e, 0xb9, 0xd6, 0xb6, 0xad, 0xc0, 0xb6, 0xa0, 0x9a, 0x2f, 0xdc, 0x17,
+	0x7d, 0xc5, 0xde, 0xc3, 0xb2, 0x21, 0x83, 0x02, 0x0d, 0xf2, 0x65, 0x3a, 0xdb, 0x24, 0xbb, 0x47,
+	0x99, 0xf3, 0x9a, 0x5d, 0x7b, 0x38, 0xbf, 0x17, 0xb0, 0x57, 0x70, 0x26, 0x95, 0x20, 0x55, 0xb5,
+	0xe5, 0xfe, 0x96, 0x06, 0xbe, 0x72, 0x16, 0x93, 0x09, 0xfb, 0x46, 0x83, 0x95, 0x60, 0x51, 0x90,
+	0xd6, 0x7b, 0x23, 0x6f, 0xa9, 0xe5, 0x30, 0x4a, 0x46, 0xec, 0x87, 0x85, 0xd6, 0xbf, 0x42, 0x98,
+	0xe7, 0xd4, 0xd5, 0xc3, 0xbf, 0xa1, 0x04, 0x0f, 0x85, 0x12, 0xde, 0x87, 0xf2, 0x0e, 0xe2, 0x31,
+	0x6f, 0x17, 0x54, 0xb2, 0x63, 0xd9, 0x38, 0x89, 0xcc, 0xde, 0xf4, 0xc6, 0x31, 0xb9, 0x57, 0xb0,
+	0x37, 0x10, 0x1f, 0x09, 0x05, 0x29, 0x1e, 0x3d, 0x6c, 0xc8, 0xd3, 0xec, 0x2d, 0x2c, 0x8c, 0xc2,
+	0xaa, 0x26, 0xc5, 0xe7, 0x0f, 0x2b, 0x27, 0x9e, 0xbd, 0x86, 0x45, 0x29, 0xf7, 0x78, 0xc2, 0xc1,
+	0x45, 0x9b, 0xec, 0xce, 0xbd, 0xf4, 0xab, 0xfc, 0x74, 0xc2, 0x21, 0x8f, 0x4b, 0xf7, 0x66, 0x2f,
+	0x21, 0xea, 0x7a, 0x7d, 0x74, 0x21, 0x27, 0xbb, 0xc4, 0x8b, 0xbe, 0xf7, 0xfa, 0x98, 0x3b, 0x82,
+	0xbd, 0x80, 0xd5, 0x41, 0x49, 0x14, 0x05, 0x6a, 0xc3, 0x97, 0xce, 0xf4, 0x5f, 0x60, 0x7d, 0x05,
+	0x91, 0xd5, 0x32, 0x06, 0x91, 0x19, 0x3a, 0xf2, 0x3b, 0xe4, 0xce, 0xff, 0xdf, 0xa0, 0x75, 0x0a,
+	0xf1, 0x78, 0x0d, 0x3b, 0x65, 0x45, 0xa8, 0x65, 0x3b, 0x6d, 0xdf, 0x58, 0xad, 0xaf, 0x60, 0x39,
+	0x79, 0x62, 0x8f, 0x61, 0x66, 0x67, 0x37, 0x0a, 0xec, 0xd1, 0x76, 0xdd, 0x61, 0xdd, 0x93, 0xe6,
+	0x61, 0x3a, 0xb3, 0x5d, 0x63, 0xf5, 0xf9, 0xf2, 0xe7, 0xf3, 0xb2, 0x32, 0xc7, 0xfe, 0x90, 0x15,
+	0xb2, 0xd9, 0x1a, 0x79, 0xc0, 0xad, 0xf3, 0xf3, 0xd1, 0x3d, 0x0f, 0xb1, 0x5b, 0xfd, 0x0f, 0x7f,
+	0x06, 0x00, 0x02, 0x93, 0x78, 0x3d, 0x2c, 0x03, 0x00, 0x00,
+}
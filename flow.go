@@ -0,0 +1,60 @@
+package wsrpc
+
+import (
+	"context"
+	"sync"
+)
+
+// window tracks how many bytes of streamed messages a client is willing to
+// buffer, similar to HTTP/2 flow control. The client grants more credit as it
+// consumes messages.
+type window struct {
+	mu     sync.Mutex
+	size   int64
+	update chan struct{} // signalled when credit is added
+}
+
+func newWindow(size uint32) *window {
+	return &window{
+		size:   int64(size),
+		update: make(chan struct{}, 1),
+	}
+}
+
+// add grants n more bytes of credit.
+func (w *window) add(n uint32) {
+	w.mu.Lock()
+	w.size += int64(n)
+	w.mu.Unlock()
+
+	select {
+	case w.update <- struct{}{}:
+	default:
+	}
+}
+
+// take waits until the window has credit then deducts n bytes. Any credit at
+// all is enough so a message larger than the window can still be sent rather
+// than stalling the stream forever.
+//
+// It gives up if the context ends or closed is closed, which happens when the
+// client connection goes away.
+func (w *window) take(ctx context.Context, closed <-chan struct{}, n int) error {
+	for {
+		w.mu.Lock()
+		if w.size > 0 {
+			w.size -= int64(n)
+			w.mu.Unlock()
+			return nil
+		}
+		w.mu.Unlock()
+
+		select {
+		case <-w.update:
+		case <-closed:
+			return ErrClientClosed
+		case <-ctx.Done():
+			return contextError(ctx.Err())
+		}
+	}
+}
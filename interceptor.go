@@ -0,0 +1,110 @@
+package wsrpc
+
+import "context"
+
+type (
+	// UnaryServerInfo holds information about a unary call that an
+	// interceptor may use or change.
+	UnaryServerInfo struct {
+		// Service implementation the call is made on.
+		Server interface{}
+		// Full method name in the gRPC form /<package>.<service>/<method>.
+		FullMethod string
+		// Connection the call was made on.
+		Client *Client
+	}
+
+	// UnaryHandler is the handler a UnaryServerInterceptor calls to continue
+	// the call, either the next interceptor or the service method.
+	UnaryHandler func(ctx context.Context, req interface{}) (interface{}, error)
+
+	// UnaryServerInterceptor intercepts unary calls on the server, equivalent
+	// to grpc.UnaryServerInterceptor. It receives the decoded request and must
+	// call handler to run the method, or may return a status error without
+	// calling it to refuse the call.
+	UnaryServerInterceptor func(ctx context.Context, req interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error)
+
+	// StreamServerInfo holds information about a streaming call that an
+	// interceptor may use.
+	StreamServerInfo struct {
+		// Full method name in the gRPC form /<package>.<service>/<method>.
+		FullMethod string
+		// Connection the call was made on.
+		Client         *Client
+		IsClientStream bool
+		IsServerStream bool
+	}
+
+	// StreamHandler is the handler a StreamServerInterceptor calls to
+	// continue the call, either the next interceptor or the service method.
+	StreamHandler func(srv interface{}, stream ServerStream) error
+
+	// StreamServerInterceptor intercepts streaming calls on the server,
+	// equivalent to grpc.StreamServerInterceptor. It may wrap the stream to
+	// see every message sent and received and must call handler to run the
+	// method, or may return a status error without calling it to refuse the
+	// call.
+	StreamServerInterceptor func(srv interface{}, stream ServerStream, info *StreamServerInfo, handler StreamHandler) error
+)
+
+// chainUnaryInterceptors combines interceptors into one which runs them in
+// order, the first being outermost. It returns nil if there are none.
+func chainUnaryInterceptors(interceptors []UnaryServerInterceptor) UnaryServerInterceptor {
+	switch len(interceptors) {
+	case 0:
+		return nil
+	case 1:
+		return interceptors[0]
+	}
+	return func(ctx context.Context, req interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error) {
+		return interceptors[0](ctx, req, info, chainUnaryHandler(interceptors, 0, info, handler))
+	}
+}
+
+// chainUnaryHandler returns the handler passed to the interceptor at index
+// curr, which calls the next interceptor or, after the last, the method.
+func chainUnaryHandler(interceptors []UnaryServerInterceptor, curr int, info *UnaryServerInfo, final UnaryHandler) UnaryHandler {
+	if curr == len(interceptors)-1 {
+		return final
+	}
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		return interceptors[curr+1](ctx, req, info, chainUnaryHandler(interceptors, curr+1, info, final))
+	}
+}
+
+// unaryInterceptor returns the interceptor passed to a method handler for a
+// request, which fills in the client before running the configured chain.
+func (s *Server) unaryInterceptor(req *Request) UnaryServerInterceptor {
+	if s.unaryInt == nil {
+		return nil
+	}
+	return func(ctx context.Context, in interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error) {
+		info.Client = req.Client
+		return s.unaryInt(ctx, in, info, handler)
+	}
+}
+
+// chainStreamInterceptors combines interceptors into one which runs them in
+// order, the first being outermost. It returns nil if there are none.
+func chainStreamInterceptors(interceptors []StreamServerInterceptor) StreamServerInterceptor {
+	switch len(interceptors) {
+	case 0:
+		return nil
+	case 1:
+		return interceptors[0]
+	}
+	return func(srv interface{}, stream ServerStream, info *StreamServerInfo, handler StreamHandler) error {
+		return interceptors[0](srv, stream, info, chainStreamHandler(interceptors, 0, info, handler))
+	}
+}
+
+// chainStreamHandler returns the handler passed to the interceptor at index
+// curr, which calls the next interceptor or, after the last, the method.
+func chainStreamHandler(interceptors []StreamServerInterceptor, curr int, info *StreamServerInfo, final StreamHandler) StreamHandler {
+	if curr == len(interceptors)-1 {
+		return final
+	}
+	return func(srv interface{}, stream ServerStream) error {
+		return interceptors[curr+1](srv, stream, info, chainStreamHandler(interceptors, curr+1, info, final))
+	}
+}
@@ -0,0 +1,133 @@
+package wsrpc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/toba/wsrpc"
+	plugin "github.com/toba/wsrpc/cmd/protoc-gen-gows/test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryInterceptors(t *testing.T) {
+	var calls []string
+	trace := func(name string) wsrpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, info *wsrpc.UnaryServerInfo, handler wsrpc.UnaryHandler) (interface{}, error) {
+			assert.Equal(t, "/plugin_test.Test/Execute", info.FullMethod)
+			assert.NotNil(t, info.Client)
+			assert.Equal(t, hello, req.(*plugin.SimpleRequest).Text)
+
+			calls = append(calls, name+" before")
+			res, err := handler(ctx, req)
+			calls = append(calls, name+" after")
+			return res, err
+		}
+	}
+	conn := connect(t, serverWith(t, wsrpc.Config{
+		UnaryInterceptors: []wsrpc.UnaryServerInterceptor{trace("first"), trace("second")},
+	}, &mockService{t: t}))
+	defer conn.Close()
+
+	call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+	r := reply(t, conn)
+	assert.Equal(t, int32(codes.OK), r.Status.GetCode())
+
+	out := &plugin.SimpleResponse{}
+	assert.NoError(t, proto.Unmarshal(r.Payload, out))
+	assert.Equal(t, world, out.Text)
+	assert.Equal(t, []string{"first before", "second before", "second after", "first after"}, calls)
+}
+
+func TestUnaryInterceptorRefuses(t *testing.T) {
+	deny := func(ctx context.Context, req interface{}, info *wsrpc.UnaryServerInfo, handler wsrpc.UnaryHandler) (interface{}, error) {
+		return nil, status.Error(codes.Unauthenticated, "no token")
+	}
+	conn := connect(t, serverWith(t, wsrpc.Config{
+		UnaryInterceptors: []wsrpc.UnaryServerInterceptor{deny},
+	}, &mockService{t: t}))
+	defer conn.Close()
+
+	call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "not checked"})
+	r := reply(t, conn)
+	assert.Equal(t, int32(codes.Unauthenticated), r.Status.Code)
+	assert.Equal(t, "no token", r.Status.Message)
+	assert.Empty(t, r.Payload)
+}
+
+// countingStream counts the messages sent and received on a stream.
+type countingStream struct {
+	wsrpc.ServerStream
+	sent, received int
+}
+
+func (s *countingStream) SendMsg(m interface{}) error {
+	s.sent++
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *countingStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.received++
+	}
+	return err
+}
+
+func TestStreamInterceptors(t *testing.T) {
+	var calls []string
+	counted := make(chan *countingStream, 1)
+
+	trace := func(srv interface{}, stream wsrpc.ServerStream, info *wsrpc.StreamServerInfo, handler wsrpc.StreamHandler) error {
+		assert.Equal(t, "/plugin_test.Test/Bidi", info.FullMethod)
+		assert.True(t, info.IsClientStream)
+		assert.True(t, info.IsServerStream)
+		assert.NotNil(t, info.Client)
+
+		calls = append(calls, "trace")
+		return handler(srv, stream)
+	}
+	count := func(srv interface{}, stream wsrpc.ServerStream, info *wsrpc.StreamServerInfo, handler wsrpc.StreamHandler) error {
+		calls = append(calls, "count")
+		cs := &countingStream{ServerStream: stream}
+		err := handler(srv, cs)
+		counted <- cs
+		return err
+	}
+	conn := connect(t, serverWith(t, wsrpc.Config{
+		StreamInterceptors: []wsrpc.StreamServerInterceptor{trace, count},
+	}, &mockService{t: t}))
+	defer conn.Close()
+
+	open(t, conn, 1, "plugin_test.Test/Bidi")
+	send(t, conn, 1, &plugin.StreamMsg{Text: "a"})
+	send(t, conn, 1, &plugin.StreamMsg{Text: "b"})
+	closeSend(t, conn, 1)
+
+	for i := 0; i < 2; i++ {
+		assert.NotEmpty(t, reply(t, conn).Payload)
+	}
+	assert.Equal(t, int32(codes.OK), reply(t, conn).Status.GetCode())
+
+	cs := <-counted
+	assert.Equal(t, 2, cs.sent)
+	assert.Equal(t, 2, cs.received)
+	assert.Equal(t, []string{"trace", "count"}, calls)
+}
+
+func TestStreamInterceptorRefuses(t *testing.T) {
+	deny := func(srv interface{}, stream wsrpc.ServerStream, info *wsrpc.StreamServerInfo, handler wsrpc.StreamHandler) error {
+		return status.Error(codes.PermissionDenied, "not yours")
+	}
+	conn := connect(t, serverWith(t, wsrpc.Config{
+		StreamInterceptors: []wsrpc.StreamServerInterceptor{deny},
+	}, &mockService{t: t}))
+	defer conn.Close()
+
+	call(t, conn, 1, "plugin_test.Test/Downstream", &plugin.SimpleRequest{Text: hello})
+	r := reply(t, conn)
+	assert.Equal(t, int32(codes.PermissionDenied), r.Status.Code)
+	assert.Empty(t, r.Payload)
+}
@@ -0,0 +1,24 @@
+package wsrpc
+
+import (
+	"log/slog"
+	"os"
+)
+
+// Keys for the structured fields attached to log events.
+const (
+	logRemote   = "remote"   // client network address
+	logMethod   = "method"   // full method name
+	logCallID   = "call_id"  // client assigned call ID
+	logCode     = "code"     // status code a call ended with
+	logDuration = "duration" // time from receiving a call to its reply
+	logError    = "error"
+)
+
+// fatal logs an error that can only come from a programming mistake, such as
+// registering a service that doesn't match its implementation, then exits
+// like log.Fatal.
+func fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
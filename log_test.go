@@ -0,0 +1,67 @@
+package wsrpc_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/toba/wsrpc"
+	plugin "github.com/toba/wsrpc/cmd/protoc-gen-gows/test"
+)
+
+// logBuffer collects JSON log lines written by concurrent goroutines.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// find returns the first event logged with the message.
+func (b *logBuffer) find(msg string) map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, line := range strings.Split(b.buf.String(), "\n") {
+		event := map[string]interface{}{}
+		if json.Unmarshal([]byte(line), &event) == nil && event["msg"] == msg {
+			return event
+		}
+	}
+	return nil
+}
+
+func TestLogger(t *testing.T) {
+	logs := &logBuffer{}
+	rpc := wsrpc.NewServer(wsrpc.Config{
+		AllowMissingOrigin: true,
+		Logger:             slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+	plugin.RegisterTestService(rpc, &mockService{t: t})
+	conn := connect(t, rpc)
+	defer conn.Close()
+
+	call(t, conn, 7, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+	reply(t, conn)
+
+	var event map[string]interface{}
+	assert.Eventually(t, func() bool {
+		event = logs.find("wsrpc: call finished")
+		return event != nil
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, "DEBUG", event["level"])
+	assert.Equal(t, "plugin_test.Test/Execute", event["method"])
+	assert.Equal(t, float64(7), event["call_id"])
+	assert.Equal(t, "OK", event["code"])
+	assert.Contains(t, event, "duration")
+	assert.NotEmpty(t, event["remote"])
+}
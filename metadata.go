@@ -0,0 +1,93 @@
+package wsrpc
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// streamKey is the context key for the serverStream of a call.
+type streamKey struct{}
+
+// MetadataFromContext returns the metadata sent by the client with the call,
+// if any. The same metadata is available from the gRPC
+// metadata.FromIncomingContext so handlers shared with gRPC services work
+// unchanged.
+func MetadataFromContext(ctx context.Context) (metadata.MD, bool) {
+	return metadata.FromIncomingContext(ctx)
+}
+
+// SetHeader sets header metadata to be sent to the client. It may be called
+// multiple times and the metadata is merged. It's an error to call it after
+// the header has been sent, which happens with the first streamed message,
+// the end of the call or an explicit SendHeader.
+func SetHeader(ctx context.Context, md metadata.MD) error {
+	ss, err := streamFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	return ss.SetHeader(md)
+}
+
+// SendHeader sends header metadata to the client immediately, merged with any
+// set by SetHeader. It may be called at most once.
+func SendHeader(ctx context.Context, md metadata.MD) error {
+	ss, err := streamFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	return ss.SendHeader(md)
+}
+
+// SetTrailer sets trailer metadata to be sent to the client with the status
+// when the call ends. It may be called multiple times and the metadata is
+// merged.
+func SetTrailer(ctx context.Context, md metadata.MD) error {
+	ss, err := streamFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	ss.SetTrailer(md)
+	return nil
+}
+
+func streamFromContext(ctx context.Context) (*serverStream, error) {
+	ss, ok := ctx.Value(streamKey{}).(*serverStream)
+	if !ok {
+		return nil, status.Errorf(codes.Internal, "wsrpc: failed to fetch the stream from the context %v", ctx)
+	}
+	return ss, nil
+}
+
+// toMetadata converts metadata to its wire form sorted by key.
+func toMetadata(md metadata.MD) []*Metadata {
+	if len(md) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := make([]*Metadata, len(keys))
+	for i, k := range keys {
+		out[i] = &Metadata{Key: k, Values: md[k]}
+	}
+	return out
+}
+
+// fromMetadata converts metadata from its wire form, lower-casing keys the
+// same as metadata.Pairs.
+func fromMetadata(entries []*Metadata) metadata.MD {
+	md := metadata.MD{}
+	for _, e := range entries {
+		k := strings.ToLower(e.Key)
+		md[k] = append(md[k], e.Values...)
+	}
+	return md
+}
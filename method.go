@@ -1,6 +1,9 @@
 package wsrpc
 
-import "context"
+import (
+	"context"
+	"strings"
+)
 
 // MethodInfo describes an RPC endpoint.
 type MethodInfo struct {
@@ -12,10 +15,55 @@ type MethodInfo struct {
 type methodHandler func(
 	service interface{},
 	ctx context.Context,
-	decoder func(interface{}) error) (interface{}, error)
+	decoder func(interface{}) error,
+	interceptor UnaryServerInterceptor) (interface{}, error)
+
+type streamHandler func(service interface{}, stream ServerStream) error
 
 // MethodMap maps a fully qualified method name to its handler.
 type MethodMap struct {
 	Name    string
 	Handler methodHandler
+	// Whether calls run one at a time per client in the order received,
+	// rather than concurrently. Set by the wsrpc.ordered method option.
+	Ordered bool
+	// Who may call the method. Set by the wsrpc.roles and wsrpc.scopes method
+	// options.
+	Policy Policy
+}
+
+// StreamMap maps a streaming method name to its handler and indicates which
+// sides of the call stream.
+type StreamMap struct {
+	Name          string
+	Handler       streamHandler
+	ServerStreams bool
+	ClientStreams bool
+	// Whether calls run one at a time per client in the order received. An
+	// ordered stream holds up later ordered calls until it ends.
+	Ordered bool
+	// Who may call the method.
+	Policy Policy
+}
+
+// splitMethodName separates a fully qualified method name of the form
+// <package>.<service>/<method> into its service and method parts. A leading
+// slash, as gRPC writes it, is allowed.
+func splitMethodName(name string) (service, method string, ok bool) {
+	name = strings.TrimPrefix(name, "/")
+	i := strings.LastIndex(name, "/")
+	if i <= 0 || i == len(name)-1 {
+		return "", "", false
+	}
+	return name[:i], name[i+1:], true
+}
+
+// methodKey returns the fully qualified method name without a leading slash,
+// the form calls are matched against Config.Policies with.
+func methodKey(name string) (string, bool) {
+	service, method, ok := splitMethodName(name)
+	if !ok {
+		return "", false
+	}
+	return service + "/" + method, true
 }
@@ -0,0 +1,73 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: options.proto
+
+package wsrpc
+
+import (
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	descriptorpb "google.golang.org/protobuf/types/descriptorpb"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+var E_Ordered = &proto.ExtensionDesc{
+	ExtendedType:  (*descriptorpb.MethodOptions)(nil),
+	ExtensionType: (*bool)(nil),
+	Field:         51300,
+	Name:          "wsrpc.ordered",
+	Tag:           "varint,51300,opt,name=ordered",
+	Filename:      "options.proto",
+}
+
+var E_Roles = &proto.ExtensionDesc{
+	ExtendedType:  (*descriptorpb.MethodOptions)(nil),
+	ExtensionType: ([]string)(nil),
+	Field:         51301,
+	Name:          "wsrpc.roles",
+	Tag:           "bytes,51301,rep,name=roles",
+	Filename:      "options.proto",
+}
+
+var E_Scopes = &proto.ExtensionDesc{
+	ExtendedType:  (*descriptorpb.MethodOptions)(nil),
+	ExtensionType: ([]string)(nil),
+	Field:         51302,
+	Name:          "wsrpc.scopes",
+	Tag:           "bytes,51302,rep,name=scopes",
+	Filename:      "options.proto",
+}
+
+func init() {
+	proto.RegisterExtension(E_Ordered)
+	proto.RegisterExtension(E_Roles)
+	proto.RegisterExtension(E_Scopes)
+}
+
+func init() { proto.RegisterFile("options.proto", fileDescriptor_110d40819f1994f9) }
+
+var fileDescriptor_110d40819f1994f9 = []byte{
+	// 177 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0xcd, 0x2f, 0x28, 0xc9,
+	0xcc, 0xcf, 0x2b, 0xd6, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x2d, 0x2f, 0x2e, 0x2a, 0x48,
+	0x96, 0x52, 0x48, 0xcf, 0xcf, 0x4f, 0xcf, 0x49, 0xd5, 0x07, 0x0b, 0x26, 0x95, 0xa6, 0xe9, 0xa7,
+	0xa4, 0x16, 0x27, 0x17, 0x65, 0x16, 0x94, 0xe4, 0x17, 0x41, 0x14, 0x5a, 0x59, 0x71, 0xb1, 0xe7,
+	0x17, 0xa5, 0xa4, 0x16, 0xa5, 0xa6, 0x08, 0xc9, 0xe9, 0x41, 0x54, 0xeb, 0xc1, 0x54, 0xeb, 0xf9,
+	0xa6, 0x96, 0x64, 0xe4, 0xa7, 0xf8, 0x43, 0x4c, 0x96, 0x78, 0x32, 0x81, 0x59, 0x81, 0x51, 0x83,
+	0x23, 0x08, 0xa6, 0xc1, 0xca, 0x8c, 0x8b, 0xb5, 0x28, 0x3f, 0x27, 0xb5, 0x98, 0xa0, 0xce, 0xa7,
+	0x13, 0x98, 0x15, 0x98, 0x35, 0x38, 0x83, 0x20, 0xca, 0xad, 0x2c, 0xb8, 0xd8, 0x8a, 0x93, 0xf3,
+	0x0b, 0x88, 0xd0, 0xf8, 0x0c, 0xaa, 0x11, 0xaa, 0xde, 0x49, 0x36, 0x4a, 0x3a, 0x3d, 0xb3, 0x24,
+	0xa3, 0x34, 0x49, 0x2f, 0x39, 0x3f, 0x57, 0xbf, 0x24, 0x3f, 0x29, 0x51, 0x1f, 0xec, 0x51, 0x6b,
+	0x30, 0x99, 0xc4, 0x06, 0x36, 0xc6, 0x18, 0x30, 0x00, 0x75, 0x2e, 0xd5, 0x26, 0x0d, 0x01, 0x00,
+	0x00,
+}
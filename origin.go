@@ -0,0 +1,62 @@
+package wsrpc
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// originChecker returns the function Handle uses to decide whether a request
+// may connect based on its Origin header.
+//
+// A custom Config.CheckOrigin decides alone. Otherwise a request without an
+// Origin is allowed only with Config.AllowMissingOrigin and one with an Origin
+// must match Config.AllowedOrigins or, if none are given, the request Host.
+func originChecker(c Config) func(r *http.Request) bool {
+	if c.CheckOrigin != nil {
+		return c.CheckOrigin
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return c.AllowMissingOrigin
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		if len(c.AllowedOrigins) == 0 {
+			return strings.EqualFold(u.Host, r.Host)
+		}
+		for _, allowed := range c.AllowedOrigins {
+			if matchOrigin(allowed, u) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// matchOrigin reports whether an origin matches an allowed origin pattern. The
+// pattern is a host, including a port if it isn't the default for the scheme,
+// optionally preceded by a scheme. A host of "*.example.com" matches any
+// subdomain of example.com but not example.com itself and "*" matches any
+// host.
+func matchOrigin(pattern string, origin *url.URL) bool {
+	if i := strings.Index(pattern, "://"); i >= 0 {
+		if !strings.EqualFold(pattern[:i], origin.Scheme) {
+			return false
+		}
+		pattern = pattern[i+3:]
+	}
+	pattern = strings.ToLower(pattern)
+	host := strings.ToLower(origin.Host)
+
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasPrefix(pattern, "*."):
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
@@ -0,0 +1,102 @@
+package wsrpc_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/toba/wsrpc"
+)
+
+// dial connects to a new server with the given Config, sending origin if it
+// isn't empty, and returns the HTTP status of the handshake.
+func dial(t *testing.T, c wsrpc.Config, origin string) int {
+	srv := httptest.NewServer(http.HandlerFunc(wsrpc.NewServer(c).Handle()))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	u.Scheme = "ws"
+
+	header := http.Header{}
+	if origin != "" {
+		header.Set("Origin", origin)
+	}
+	conn, res, err := websocket.DefaultDialer.Dial(u.String(), header)
+	if err == nil {
+		conn.Close()
+	}
+	if !assert.NotNil(t, res) {
+		return 0
+	}
+	return res.StatusCode
+}
+
+func TestOriginPolicy(t *testing.T) {
+	allowed := wsrpc.Config{
+		AllowedOrigins: []string{"app.example.com", "https://*.example.org", "localhost:3000"},
+	}
+
+	for _, test := range []struct {
+		name   string
+		config wsrpc.Config
+		origin string
+		ok     bool
+	}{
+		{"missing origin denied by default", wsrpc.Config{}, "", false},
+		{"missing origin allowed", wsrpc.Config{AllowMissingOrigin: true}, "", true},
+		{"other origin denied by default", wsrpc.Config{}, "http://evil.com", false},
+		{"malformed origin", wsrpc.Config{}, "::nonsense", false},
+		{"exact host", allowed, "https://app.example.com", true},
+		{"exact host any scheme", allowed, "http://app.example.com", true},
+		{"exact host wrong port", allowed, "https://app.example.com:8443", false},
+		{"host with port", allowed, "http://localhost:3000", true},
+		{"subdomain", allowed, "https://a.b.example.org", true},
+		{"subdomain wrong scheme", allowed, "http://a.example.org", false},
+		{"wildcard excludes apex", allowed, "https://example.org", false},
+		{"suffix is not subdomain", allowed, "https://badexample.org", false},
+		{"not listed", allowed, "https://example.com", false},
+		{"any origin", wsrpc.Config{AllowedOrigins: []string{"*"}}, "https://anywhere.net", true},
+		{"custom check", wsrpc.Config{
+			CheckOrigin: func(r *http.Request) bool {
+				return strings.HasSuffix(r.Header.Get("Origin"), ".test")
+			},
+		}, "http://my.test", true},
+		{"custom check denies", wsrpc.Config{
+			CheckOrigin: func(r *http.Request) bool { return false },
+		}, "", false},
+	} {
+		expect := http.StatusForbidden
+		if test.ok {
+			expect = http.StatusSwitchingProtocols
+		}
+		assert.Equal(t, expect, dial(t, test.config, test.origin), test.name)
+	}
+}
+
+func TestCheckOriginOnce(t *testing.T) {
+	var calls int32
+	c := wsrpc.Config{CheckOrigin: func(r *http.Request) bool {
+		atomic.AddInt32(&calls, 1)
+		return true
+	}}
+	assert.Equal(t, http.StatusSwitchingProtocols, dial(t, c, "http://my.test"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestSameOrigin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(wsrpc.NewServer(wsrpc.Config{}).Handle()))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	u.Scheme = "ws"
+
+	conn, res, err := websocket.DefaultDialer.Dial(u.String(), http.Header{"Origin": {srv.URL}})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+	conn.Close()
+}
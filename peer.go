@@ -0,0 +1,72 @@
+package wsrpc
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// clientKey is the context key for the Client of a call.
+type clientKey struct{}
+
+// Peer describes the client end of a connection, like the gRPC peer.Peer.
+type Peer struct {
+	// Network address of the client, or of the last proxy in front of it.
+	Addr net.Addr
+	// TLS state of the connection or nil if it isn't secure.
+	TLS *tls.ConnectionState
+	// User-Agent header sent when connecting.
+	UserAgent string
+	// Token the client is currently authenticated with, if any.
+	Token *oauth2.Token
+}
+
+// ClientFromContext returns the client connection a call was made on.
+func ClientFromContext(ctx context.Context) (*Client, bool) {
+	c, ok := ctx.Value(clientKey{}).(*Client)
+	return c, ok
+}
+
+// PeerFromContext returns information about the client that made a call. The
+// same address and TLS state are available from the gRPC peer.FromContext so
+// handlers shared with gRPC services work unchanged.
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	c, ok := ClientFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	return c.Peer(), true
+}
+
+// Peer returns information about the client end of the connection.
+func (c *Client) Peer() *Peer {
+	p := &Peer{
+		Addr:      c.conn.RemoteAddr(),
+		TLS:       c.request.TLS,
+		UserAgent: c.request.UserAgent(),
+	}
+	if id := c.Identity(); id != nil {
+		p.Token = id.Token
+	}
+	return p
+}
+
+// newClientContext returns the parent context for calls on a client
+// connection, carrying the client and its gRPC peer.
+func newClientContext(parent context.Context, c *Client, r *http.Request) context.Context {
+	ctx := context.WithValue(parent, clientKey{}, c)
+
+	p := &peer.Peer{Addr: c.conn.RemoteAddr()}
+	if r.TLS != nil {
+		p.AuthInfo = credentials.TLSInfo{
+			State:          *r.TLS,
+			CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+		}
+	}
+	return peer.NewContext(ctx, p)
+}
@@ -0,0 +1,74 @@
+package wsrpc_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/toba/wsrpc"
+	plugin "github.com/toba/wsrpc/cmd/protoc-gen-gows/test"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+func TestPeerFromContext(t *testing.T) {
+	peers := make(chan *wsrpc.Peer, 1)
+	m := &mockService{
+		t: t,
+		execute: func(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error) {
+			c, ok := wsrpc.ClientFromContext(ctx)
+			assert.True(t, ok)
+			assert.NotNil(t, c)
+
+			gp, ok := peer.FromContext(ctx)
+			if assert.True(t, ok) {
+				assert.IsType(t, credentials.TLSInfo{}, gp.AuthInfo)
+			}
+
+			p, _ := wsrpc.PeerFromContext(ctx)
+			peers <- p
+			return &plugin.SimpleResponse{}, nil
+		},
+	}
+	rpc := wsrpc.NewServer(wsrpc.Config{
+		AllowMissingOrigin: true,
+		Authenticate: func(r *http.Request) (*wsrpc.Identity, error) {
+			return &wsrpc.Identity{Subject: "alice", Token: &oauth2.Token{AccessToken: "secret"}}, nil
+		},
+	})
+	plugin.RegisterTestService(rpc, m)
+	srv := httptest.NewTLSServer(http.HandlerFunc(rpc.Handle()))
+	defer srv.Close()
+
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	conn, _, err := dialer.Dial(strings.Replace(srv.URL, "https", "wss", 1), http.Header{"User-Agent": {"wsrpc-test"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+	assert.Equal(t, int32(codes.OK), reply(t, conn).Status.GetCode())
+
+	p := <-peers
+	if assert.NotNil(t, p) {
+		assert.Equal(t, conn.LocalAddr().String(), p.Addr.String())
+		assert.NotNil(t, p.TLS)
+		assert.Equal(t, "wsrpc-test", p.UserAgent)
+		assert.Equal(t, "secret", p.Token.AccessToken)
+	}
+}
+
+func TestPeerFromContextOutsideCall(t *testing.T) {
+	_, ok := wsrpc.PeerFromContext(context.Background())
+	assert.False(t, ok)
+	_, ok = wsrpc.ClientFromContext(context.Background())
+	assert.False(t, ok)
+}
@@ -0,0 +1,94 @@
+package wsrpc
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Policy limits which identities may call a method. The zero Policy allows
+// anyone.
+type Policy struct {
+	// Roles allowed to call the method. A caller needs at least one of them.
+	Roles []string
+	// Scopes needed to call the method. A caller needs all of them.
+	Scopes []string
+}
+
+// empty indicates whether the policy allows anyone.
+func (p Policy) empty() bool {
+	return len(p.Roles) == 0 && len(p.Scopes) == 0
+}
+
+// allows reports whether the identity satisfies the policy.
+func (p Policy) allows(id *Identity) bool {
+	if len(p.Roles) > 0 && !containsAny(id.Roles, p.Roles) {
+		return false
+	}
+	for _, scope := range p.Scopes {
+		if !contains(id.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(have []string, want string) bool {
+	for _, h := range have {
+		if h == want {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(have, want []string) bool {
+	for _, w := range want {
+		if contains(have, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizePolicies returns Config.Policies keyed by methodKey so a name with
+// a leading slash matches the same calls as one without. It's an error for a
+// name to be malformed or for two names to be the same method.
+func normalizePolicies(policies map[string]Policy) (map[string]Policy, error) {
+	normal := make(map[string]Policy, len(policies))
+	for name, p := range policies {
+		key, ok := methodKey(name)
+		if !ok {
+			return nil, fmt.Errorf("wsrpc: Config.Policies has malformed method name %q", name)
+		}
+		if _, dup := normal[key]; dup {
+			return nil, fmt.Errorf("wsrpc: Config.Policies has more than one entry for %v", key)
+		}
+		normal[key] = p
+	}
+	return normal, nil
+}
+
+// authorize checks a call against the policy for its method, which is the
+// one in Config.Policies if there is one or else the one registered with the
+// method. The error is an Unauthenticated status if the client has no
+// identity and PermissionDenied if its identity doesn't satisfy the policy.
+func (s *Server) authorize(req *Request, registered Policy) error {
+	name, _ := methodKey(req.Method)
+	p, ok := s.policies[name]
+	if !ok {
+		p = registered
+	}
+	if p.empty() {
+		return nil
+	}
+	id := req.Client.Identity()
+	if id == nil {
+		return status.Errorf(codes.Unauthenticated, "wsrpc: %v requires an authenticated client", name)
+	}
+	if !p.allows(id) {
+		return status.Errorf(codes.PermissionDenied, "wsrpc: %v is not allowed to call %v", id.Subject, name)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package wsrpc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+)
+
+var (
+	// ErrClientNotFound indicates there's no connected client with an ID.
+	ErrClientNotFound = errors.New("wsrpc: no client with that ID is connected")
+
+	// ErrUserNotConnected indicates there's no connected client with a user
+	// identity.
+	ErrUserNotConnected = errors.New("wsrpc: the user has no connected clients")
+
+	// ErrSendQueueFull indicates a message wasn't sent because the client
+	// isn't keeping up with the messages already queued for it.
+	ErrSendQueueFull = errors.New("wsrpc: the client send queue is full")
+)
+
+// newClientID returns a random ID for a client connection.
+func newClientID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("wsrpc: can't generate a client ID: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// SendTo pushes a message to the client with the ID, framed as a Push naming
+// the message type so the browser can pass it to the matching listener.
+//
+// A nil error means the message was queued for the client, not that the
+// client received it. A client whose queue is full is skipped with
+// ErrSendQueueFull rather than holding up the caller.
+func (s *Server) SendTo(clientID string, msg proto.Message) error {
+	s.mu.RLock()
+	c, ok := s.ids[clientID]
+	s.mu.RUnlock()
+	if !ok {
+		return ErrClientNotFound
+	}
+	b, err := s.push(msg)
+	if err != nil {
+		return err
+	}
+	return c.trySend(b)
+}
+
+// SendToUser pushes a message, as with SendTo, to every client connected with
+// the identity subject. The error joins those for each client that couldn't
+// be sent the message, or is ErrUserNotConnected if there are none.
+func (s *Server) SendToUser(subject string, msg proto.Message) error {
+	var clients []*Client
+	s.mu.RLock()
+	for c := range s.clients {
+		if id := c.Identity(); id != nil && id.Subject == subject {
+			clients = append(clients, c)
+		}
+	}
+	s.mu.RUnlock()
+	if len(clients) == 0 {
+		return ErrUserNotConnected
+	}
+
+	b, err := s.push(msg)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, c := range clients {
+		if err := c.trySend(b); err != nil {
+			errs = append(errs, fmt.Errorf("client %s: %w", c.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// push encodes a message in a Reply for pushing to clients.
+func (s *Server) push(msg proto.Message) ([]byte, error) {
+	payload, err := s.codec.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("wsrpc: error marshaling push: %w", err)
+	}
+	b, err := s.codec.Marshal(&Reply{Push: &Push{Type: proto.MessageName(msg), Payload: payload}})
+	if err != nil {
+		return nil, fmt.Errorf("wsrpc: error marshaling push: %w", err)
+	}
+	return b, nil
+}
+
+// trySend queues a message for the client without waiting.
+func (c *Client) trySend(b []byte) error {
+	select {
+	case <-c.done:
+		return ErrClientClosed
+	default:
+	}
+	select {
+	case c.Send <- b:
+		return nil
+	case <-c.done:
+		return ErrClientClosed
+	default:
+		return ErrSendQueueFull
+	}
+}
@@ -0,0 +1,95 @@
+package wsrpc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/toba/wsrpc"
+	plugin "github.com/toba/wsrpc/cmd/protoc-gen-gows/test"
+	"google.golang.org/grpc/codes"
+)
+
+// userAuth authenticates each client as the user named by its token.
+func userAuth(r *http.Request) (*wsrpc.Identity, error) {
+	return &wsrpc.Identity{Subject: wsrpc.BearerToken(r)}, nil
+}
+
+// idService replies to Execute with the client ID of the caller.
+func idService(t *testing.T) *mockService {
+	return &mockService{
+		t: t,
+		execute: func(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error) {
+			c, _ := wsrpc.ClientFromContext(ctx)
+			return &plugin.SimpleResponse{Text: c.ID}, nil
+		},
+	}
+}
+
+// clientID asks the server for the ID of the connection.
+func clientID(t *testing.T, conn *websocket.Conn) string {
+	call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+	out := &plugin.SimpleResponse{}
+	assert.NoError(t, proto.Unmarshal(reply(t, conn).Payload, out))
+	return out.Text
+}
+
+// pushed reads a Push from the connection and decodes its message.
+func pushed(t *testing.T, conn *websocket.Conn) *plugin.StreamMsg {
+	r := reply(t, conn)
+	if !assert.NotNil(t, r.Push) {
+		return nil
+	}
+	assert.Equal(t, "plugin_test.StreamMsg", r.Push.Type)
+	msg := &plugin.StreamMsg{}
+	assert.NoError(t, proto.Unmarshal(r.Push.Payload, msg))
+	return msg
+}
+
+func TestSendTo(t *testing.T) {
+	rpc := serverWith(t, wsrpc.Config{Authenticate: userAuth}, idService(t))
+	addr := serve(t, rpc)
+	conn := dialURL(t, addr+"?access_token=alice")
+	id := clientID(t, conn)
+	assert.NotEmpty(t, id)
+
+	assert.NoError(t, rpc.SendTo(id, &plugin.StreamMsg{Text: "ping"}))
+	assert.Equal(t, "ping", pushed(t, conn).GetText())
+
+	assert.Equal(t, wsrpc.ErrClientNotFound, rpc.SendTo("missing", &plugin.StreamMsg{}))
+
+	conn.Close()
+	assert.Eventually(t, func() bool {
+		return errors.Is(rpc.SendTo(id, &plugin.StreamMsg{}), wsrpc.ErrClientNotFound)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestSendToUser(t *testing.T) {
+	rpc := serverWith(t, wsrpc.Config{Authenticate: userAuth}, idService(t))
+	addr := serve(t, rpc)
+	alice1 := dialURL(t, addr+"?access_token=alice")
+	alice2 := dialURL(t, addr+"?access_token=alice")
+	bob := dialURL(t, addr+"?access_token=bob")
+
+	// make sure every connection is registered
+	for _, conn := range []*websocket.Conn{alice1, alice2, bob} {
+		clientID(t, conn)
+	}
+
+	assert.NoError(t, rpc.SendToUser("alice", &plugin.StreamMsg{Text: "hi alice"}))
+	assert.Equal(t, "hi alice", pushed(t, alice1).GetText())
+	assert.Equal(t, "hi alice", pushed(t, alice2).GetText())
+
+	// bob's next message is the reply to his own call
+	call(t, bob, 2, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+	r := reply(t, bob)
+	assert.Nil(t, r.Push)
+	assert.Equal(t, int32(codes.OK), r.Status.GetCode())
+
+	assert.Equal(t, wsrpc.ErrUserNotConnected, rpc.SendToUser("carol", &plugin.StreamMsg{}))
+}
@@ -5,31 +5,45 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // Server is a WebSocket server for RPC requests. The gRPC server assigns the
 // codec per client connection but this implementation always uses protobuf so
 // it can be defined at the server level.
 type Server struct {
-	mu         sync.RWMutex
-	clients    map[*Client]bool
-	request    chan *Request
-	broadcast  chan []byte
-	register   chan *Client
-	unregister chan *Client
-	services   map[string]*ServiceMap // service name -> service info
-	active     bool                   // whether server is processing requests
-	codec      grpc.Codec
-	ctx        context.Context
-	cancel     context.CancelFunc
+	mu        sync.RWMutex
+	clients   map[*Client]bool
+	ids       map[string]*Client // clients by ID
+	request   chan *Request
+	streams   chan struct{} // one slot per open streaming call
+	broadcast chan []byte
+	services  map[string]*ServiceMap // service name -> service info
+	draining  bool                   // whether new connections and calls are refused
+	stopped   bool
+	calls     sync.WaitGroup // calls in progress
+	quit      chan struct{}  // closed when the server stops
+	start     sync.Once
+	codec     grpc.Codec
+	ctx       context.Context
+	cancel    context.CancelFunc
+	config    Config
+	policies  map[string]Policy // Config.Policies keyed by methodKey
+	log       *slog.Logger
+	upgrader  websocket.Upgrader
+	origin    func(r *http.Request) bool // checked by Handle before the upgrade
+	unaryInt  UnaryServerInterceptor     // chain of Config.UnaryInterceptors
+	streamInt StreamServerInterceptor    // chain of Config.StreamInterceptors
 }
 
 type (
@@ -40,7 +54,13 @@ type (
 		ReceivedAt time.Time
 		RawMessage []byte
 		WireLength int
+		ID         uint32      // client assigned call ID from the envelope
+		Method     string      // fully qualified method name from the envelope
 		Message    interface{} // decoded proto message
+		call       *Call
+		stream     *serverStream
+		ordered    bool // whether the call holds its client's ordering key
+		streaming  bool // whether the call is to a streaming method
 	}
 
 	// RequestHandler processes a socket request and returns a response that
@@ -61,19 +81,44 @@ var (
 	// ErrServerStopped indicates that the operation is now illegal because of
 	// the server being stopped.
 	ErrServerStopped = errors.New("wsrpc: the server has been stopped")
+
+	// ErrClientClosed indicates that a message couldn't be sent because the
+	// client connection has closed.
+	ErrClientClosed = errors.New("wsrpc: the client connection is closed")
 )
 
 // NewServer creates a websocket server which has no service registered and is
-// not yet accepting requests.
+// not yet accepting requests. Zero values in the Config are replaced with
+// defaults. It panics if the Config is invalid; use Config.Validate to check
+// first.
 func NewServer(c Config) *Server {
+	if err := c.Validate(); err != nil {
+		panic(err)
+	}
+	c = c.withDefaults()
+	policies, _ := normalizePolicies(c.Policies)
+
 	s := &Server{
-		broadcast:  make(chan []byte),
-		request:    make(chan *Request),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		clients:    make(map[*Client]bool),
-		services:   make(map[string]*ServiceMap),
-		codec:      protoCodec{},
+		broadcast: make(chan []byte),
+		request:   make(chan *Request, c.CallQueueSize),
+		streams:   make(chan struct{}, c.MaxStreams),
+		clients:   make(map[*Client]bool),
+		ids:       make(map[string]*Client),
+		services:  make(map[string]*ServiceMap),
+		quit:      make(chan struct{}),
+		codec:     protoCodec{},
+		config:    c,
+		policies:  policies,
+		log:       c.Logger,
+		unaryInt:  chainUnaryInterceptors(c.UnaryInterceptors),
+		streamInt: chainStreamInterceptors(c.StreamInterceptors),
+		origin:    originChecker(c),
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  c.ReadBufferSize,
+			WriteBufferSize: c.WriteBufferSize,
+			// Handle has already checked the origin
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
 	}
 	s.ctx, s.cancel = context.WithCancel(context.Background())
 
@@ -81,111 +126,382 @@ func NewServer(c Config) *Server {
 }
 
 // Handle incoming WebSocket requests. Create a client object for each
-// connection with a read and write event loop.
+// connection with a read and write event loop. Requests are refused with 503
+// Service Unavailable once the server is stopping.
 func (s *Server) Handle() func(w http.ResponseWriter, r *http.Request) {
 
-	go s.listen()
+	s.start.Do(func() {
+		go s.listen()
+		for i := 0; i < s.config.Workers; i++ {
+			go s.work()
+		}
+	})
 
 	// return standard HTTP handler that upgrades to socket connection
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if rvr := recover(); rvr != nil {
-				fmt.Fprintf(os.Stderr, "Panic: %+v\n", rvr)
-				debug.PrintStack()
+				s.log.Error("wsrpc: panic handling connection", logRemote, r.RemoteAddr,
+					"panic", fmt.Sprint(rvr), "stack", string(debug.Stack()))
 				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			}
 		}()
 
-		conn, err := upgrader.Upgrade(w, r, nil)
+		if s.refusing() {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		// check the origin before authenticating so a cross-site page can't
+		// use the browser's credentials to probe them
+		if !s.origin(r) {
+			s.log.Warn("wsrpc: origin not allowed", logRemote, r.RemoteAddr, "origin", r.Header.Get("Origin"))
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		id, ok := s.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		conn, err := s.upgrader.Upgrade(w, r, nil)
 
 		if err != nil {
-			log.Println(err)
-			//http.Error(w, fmt.Sprintf("cannot upgrade: %v", err), http.StatusInternalServerError)
+			// the upgrader has already replied with an HTTP error
+			s.log.Warn("wsrpc: websocket upgrade failed", logRemote, r.RemoteAddr, logError, err)
+			return
+		}
+		client := &Client{
+			ID:        newClientID(),
+			conn:      conn,
+			server:    s,
+			log:       s.log.With(logRemote, conn.RemoteAddr().String()),
+			Send:      make(chan []byte, s.config.SendQueueSize),
+			done:      make(chan struct{}),
+			streams:   make(map[uint32]*serverStream),
+			sequences: make(map[string][]*Request),
+			request:   r.Clone(context.Background()),
+		}
+		if id != nil {
+			client.Token = id.Token
+		}
+		client.ctx, client.cancel = context.WithCancel(newClientContext(s.ctx, client, r))
+		client.setIdentity(id)
+		if !s.addClient(client) {
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, ErrServerStopped.Error()))
+			conn.Close()
 			return
 		}
-		client := &Client{conn: conn, server: s, Send: make(chan []byte, 256)}
-		s.register <- client
 
+		client.log.Debug("wsrpc: client connected")
+		client.expireAt(id)
 		go client.writePump()
 		go client.readPump()
 	}
 }
 
-// remove closes the client Send channel and removes it from the server map.
-func (s *Server) remove(c *Client) {
-	close(c.Send)
+// refusing indicates whether the server is stopping and so accepts no new
+// connections or calls.
+func (s *Server) refusing() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.draining
+}
+
+// addClient adds a client to the server map unless the server is stopping.
+func (s *Server) addClient(c *Client) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.clients, c)
+	if s.draining {
+		return false
+	}
+	s.clients[c] = true
+	s.ids[c.ID] = c
+	return true
 }
 
-// listen is an event loop that continually checks event channels.
-//
-// The request case is equivalent to the gRPC clientStream.RecvMsg() or
-// Server.processUnaryRPC() which decode a binary message.
-//
-// In gRPC, the service and method name are sent in the request header which
-// are used to lookup the handler implementation.
-func (s *Server) listen() {
-	for {
-		select {
-		case c := <-s.register:
-			s.clients[c] = true
+// remove signals the client to close and removes it from the server map. It
+// does nothing if the client was already removed.
+func (s *Server) remove(c *Client) {
+	s.disconnect(c, websocket.CloseNormalClosure, "")
+}
 
-		case c := <-s.unregister:
-			if _, ok := s.clients[c]; ok {
-				s.remove(c)
-			}
+// disconnect removes the client like remove but closes the connection with
+// the given close code and text.
+func (s *Server) disconnect(c *Client, code int, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[c]; ok {
+		delete(s.clients, c)
+		delete(s.ids, c.ID)
+		c.close(code, text)
+	}
+}
 
-		case req := <-s.request:
-			var msg interface{}
-			// TODO: source these objects since we can't get them from a header
-			md := MethodMap{}
-			srv := ServiceMap{}
+// startCall counts a new call unless the server is stopping. Each started call
+// must be ended with s.calls.Done().
+func (s *Server) startCall() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.draining {
+		return false
+	}
+	s.calls.Add(1)
+	return true
+}
 
-			df := func(v interface{}) error {
-				return nil
-			}
+// Stop closes all client connections, sending each a close frame, and cancels
+// calls that are still running. New connections are refused.
+func (s *Server) Stop() {
+	s.stop(websocket.CloseGoingAway, "server stopped")
+}
 
-			if err := s.codec.Unmarshal(req.RawMessage, msg); err != nil {
-				log.Fatal("oops")
-			}
+// Drain tells every client the server is going away so it opens a new
+// connection for new calls while calls in progress finish on this one. From
+// then on the server refuses new connections and calls. The reason is passed
+// to clients for logging.
+//
+// Drain returns without waiting for calls to finish. Follow it with
+// GracefulStop, or Stop once clients have had time to move.
+func (s *Server) Drain(reason string) {
+	s.mu.Lock()
+	if s.draining {
+		s.mu.Unlock()
+		return
+	}
+	s.draining = true
+	clients := make([]*Client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
 
-			req.Message = msg
-			req.ReceivedAt = time.Now()
+	b, err := s.codec.Marshal(&Reply{GoAway: &GoAway{Reason: reason}})
+	if err != nil {
+		s.log.Error("wsrpc: error marshaling GoAway", logError, err)
+		return
+	}
+	for _, c := range clients {
+		// a client with a full queue mustn't hold up the others
+		go c.send(b)
+	}
+}
 
-			reply, err := md.Handler(srv.service, s.ctx, df)
+// GracefulStop drains the server, as with Drain, then waits for calls in
+// progress to finish before closing each connection with a Service Restart
+// close frame telling clients to reconnect, which will reach another server
+// behind a load balancer.
+//
+// If ctx ends before the calls finish, the server is stopped immediately as
+// with Stop and the context error is returned.
+func (s *Server) GracefulStop(ctx context.Context) error {
+	s.Drain("server stopping")
 
-			if err != nil {
-				log.Fatal("oops")
-			}
+	finished := make(chan struct{})
+	go func() {
+		s.calls.Wait()
+		close(finished)
+	}()
 
-			res, err := s.codec.Marshal(reply)
+	select {
+	case <-finished:
+		s.stop(websocket.CloseServiceRestart, "server restarting; reconnect")
+		return nil
+	case <-ctx.Done():
+		s.Stop()
+		return ctx.Err()
+	}
+}
 
-			if err != nil {
-				log.Fatal("oops")
-			}
+// stop closes every client with the given close code and text, cancels the
+// server context and ends the listen loop.
+func (s *Server) stop(code int, text string) {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.stopped = true
+	s.draining = true
+	clients := s.clients
+	s.clients = make(map[*Client]bool)
+	s.ids = make(map[string]*Client)
+	s.mu.Unlock()
 
-			if res != nil {
-				req.Client.Send <- res
-			}
+	for c := range clients {
+		c.close(code, text)
+	}
+	s.cancel()
+	close(s.quit)
+}
+
+// listen is an event loop that sends broadcast messages. Calls are run by
+// workers so slow handlers don't hold it up.
+func (s *Server) listen() {
+	for {
+		select {
+		case <-s.quit:
+			return
 
 		case res := <-s.broadcast:
+			s.mu.RLock()
 			for c := range s.clients {
 				select {
 				case c.Send <- res:
 				default:
-					s.remove(c)
+					// Skip a client that isn't keeping up rather than
+					// dropping its connection and every call on it.
+					c.log.Warn("wsrpc: broadcast skipped slow client")
 				}
 			}
+			s.mu.RUnlock()
+		}
+	}
+}
+
+// handle invokes the method handler for a request and queues the reply
+// tagged with the call ID. Replies may therefore reach the client in a
+// different order than the calls were made.
+//
+// Failures are reported to the calling client as a status in the reply rather
+// than affecting the server or other clients. Nothing is sent if the client
+// canceled the call.
+func (s *Server) handle(req *Request) {
+	defer req.Client.next()
+	defer req.Client.release(req)
+	defer s.calls.Done()
+	defer req.stream.cancel()
+
+	payload, err := s.process(req)
+	if overflow := req.stream.overflowed(); overflow != nil {
+		payload, err = nil, overflow
+	}
+
+	req.Client.closeStream(req.stream)
+	if req.stream.canceledByClient() {
+		req.Client.log.Debug("wsrpc: call canceled", logMethod, req.Method, logCallID, req.ID,
+			logDuration, time.Since(req.ReceivedAt))
+		return
+	}
+	res := req.stream.last(payload)
+	s.finish(req.Client, res, err)
+	req.Client.log.Debug("wsrpc: call finished", logMethod, req.Method, logCallID, req.ID,
+		logCode, codes.Code(res.Status.GetCode()).String(), logDuration, time.Since(req.ReceivedAt))
+}
+
+// process runs the method for a request, returning the reply payload for a
+// unary method. A panic in the handler or an interceptor is recovered and
+// reported to the client with status Internal.
+func (s *Server) process(req *Request) (payload []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			req.Client.log.Error("wsrpc: panic in call", logMethod, req.Method, logCallID, req.ID,
+				"panic", fmt.Sprint(r), "stack", string(stack))
+			if s.config.PanicHandler != nil {
+				s.config.PanicHandler(req.stream.ctx, req.Method, r, stack)
+			}
+			payload, err = nil, status.Errorf(codes.Internal, "wsrpc: panic in %v", req.Method)
+		}
+	}()
+
+	srv, method, err := s.lookup(req.Method)
+	if err != nil {
+		return nil, err
+	}
+	if md, ok := srv.methods[method]; ok {
+		if err := s.authorize(req, md.Policy); err != nil {
+			return nil, err
+		}
+		return s.processUnary(req, srv, md)
+	}
+	if sd, ok := srv.streams[method]; ok {
+		if err := s.authorize(req, sd.Policy); err != nil {
+			return nil, err
+		}
+		return nil, s.processStreaming(req, srv, sd)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "unknown method %v", req.Method)
+}
+
+// processUnary is equivalent to the gRPC Server.processUnaryRPC() which
+// decodes a binary message. In gRPC, the service and method name are sent in
+// the request header which are used to lookup the handler implementation. Here
+// they are sent in the Call envelope that wraps the encoded request message.
+//
+// The returned error, if any, is a status error.
+func (s *Server) processUnary(req *Request, srv *ServiceMap, md *MethodMap) ([]byte, error) {
+	req.stream.single(req.call.Payload)
+
+	reply, err := md.Handler(srv.service, req.stream.ctx, req.stream.RecvMsg, s.unaryInterceptor(req))
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := s.codec.Marshal(reply)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "wsrpc: error while marshaling: %v", err)
+	}
+	return payload, nil
+}
+
+// processStreaming runs a streaming method handler. Messages the handler sends
+// are written to the client as they're produced and the handler's returned
+// error becomes the trailing status.
+func (s *Server) processStreaming(req *Request, srv *ServiceMap, sd *StreamMap) error {
+	if !sd.ClientStreams {
+		req.stream.single(req.call.Payload)
+	}
+	if s.streamInt == nil {
+		return sd.Handler(srv.service, req.stream)
+	}
+	info := &StreamServerInfo{
+		FullMethod:     "/" + strings.TrimPrefix(req.Method, "/"),
+		Client:         req.Client,
+		IsClientStream: sd.ClientStreams,
+		IsServerStream: sd.ServerStreams,
+	}
+	return s.streamInt(srv.service, req.stream, info, StreamHandler(sd.Handler))
+}
+
+// finish sets the status of err on the last reply for a call and sends it.
+// Context errors are reported as Canceled or DeadlineExceeded and any other
+// errors that didn't come from the status package with code Unknown, the same
+// as gRPC.
+func (s *Server) finish(c *Client, res *Reply, err error) {
+	st := status.New(codes.OK, "")
+	if err != nil {
+		var ok bool
+		if st, ok = status.FromError(err); !ok {
+			st, _ = status.FromError(contextError(err))
 		}
 	}
+
+	res.Status = st.Proto()
+	b, err := s.codec.Marshal(res)
+	if err != nil {
+		c.log.Error("wsrpc: error marshaling reply", logCallID, res.Id, logError, err)
+		return
+	}
+	c.send(b)
 }
 
 // Broadcast puts a message onto the broadcast channel to be sent to all
-// connected clients.
+// connected clients. It's framed in a Reply like every other message so
+// clients find it in the broadcast field. It does nothing once the server has
+// stopped.
 func (s *Server) Broadcast(res []byte) {
-	if s.broadcast != nil && res != nil {
-		s.broadcast <- res
+	if s.broadcast == nil || res == nil {
+		return
+	}
+	b, err := s.codec.Marshal(&Reply{Broadcast: res})
+	if err != nil {
+		s.log.Error("wsrpc: error marshaling broadcast", logError, err)
+		return
+	}
+	select {
+	case s.broadcast <- b:
+	case <-s.quit:
 	}
 }
@@ -1,26 +1,114 @@
 package wsrpc_test
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/toba/wsrpc"
+	plugin "github.com/toba/wsrpc/cmd/protoc-gen-gows/test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 var (
-	c     = wsrpc.Config{}
-	hello = []byte("hello")
-	world = []byte("world")
+	c     = wsrpc.Config{AllowMissingOrigin: true}
+	hello = "hello"
+	world = "world"
 )
 
+type mockService struct {
+	t       *testing.T
+	sent    int32 // messages sent by Downstream
+	execute func(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error)
+	// sequence handles Sequence calls, echoing the request when nil
+	sequence func(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error)
+}
+
+func (m *mockService) Execute(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error) {
+	if m.execute != nil {
+		return m.execute(ctx, in)
+	}
+	assert.Equal(m.t, hello, in.Text)
+	return &plugin.SimpleResponse{Text: world}, nil
+}
+
+func (m *mockService) Sequence(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error) {
+	if m.sequence != nil {
+		return m.sequence(ctx, in)
+	}
+	return &plugin.SimpleResponse{Text: in.Text}, nil
+}
+
+func (m *mockService) Admin(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error) {
+	return &plugin.SimpleResponse{Text: in.Text}, nil
+}
+
+// Downstream sends each character of the request text as its own message.
+func (m *mockService) Downstream(in *plugin.SimpleRequest, stream plugin.Test_DownstreamServer) error {
+	if md, _ := metadata.FromIncomingContext(stream.Context()); len(md["header"]) > 0 {
+		stream.SetHeader(metadata.Pairs("header", md["header"][0]))
+		stream.SetTrailer(metadata.Pairs("count", strconv.Itoa(len(in.Text))))
+	}
+	for _, r := range in.Text {
+		if err := stream.Send(&plugin.StreamMsg{Text: string(r)}); err != nil {
+			return err
+		}
+		atomic.AddInt32(&m.sent, 1)
+	}
+	if in.Text == "" {
+		return status.Error(codes.InvalidArgument, "nothing to stream")
+	}
+	return nil
+}
+
+// Upstream replies with the text of every message received joined together.
+func (m *mockService) Upstream(stream plugin.Test_UpstreamServer) error {
+	text := ""
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&plugin.SimpleResponse{Text: text})
+		}
+		if err != nil {
+			return err
+		}
+		text += msg.Text
+	}
+}
+
+// Bidi echoes each message received with an exclamation.
+func (m *mockService) Bidi(stream plugin.Test_BidiServer) error {
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&plugin.StreamMsg2{Text: msg.Text + "!"}); err != nil {
+			return err
+		}
+	}
+}
+
 // https://play.golang.org/p/X8GLU-Gcox
-func connect(t *testing.T, h wsrpc.RequestHandler) *websocket.Conn {
-	rpc := wsrpc.NewServer(c)
-	handler := rpc.Handle(h)
+func connect(t *testing.T, rpc *wsrpc.Server) *websocket.Conn {
+	handler := rpc.Handle()
 	srv := httptest.NewServer(http.HandlerFunc(handler))
 	u, _ := url.Parse(srv.URL)
 	u.Scheme = "ws"
@@ -37,24 +125,642 @@ func connect(t *testing.T, h wsrpc.RequestHandler) *websocket.Conn {
 	return conn
 }
 
-func mockHandler(t *testing.T) wsrpc.RequestHandler {
-	return func(req *wsrpc.Request) []byte {
-		assert.NotNil(t, req)
-		assert.Equal(t, hello, req.Message)
-		return world
+func mockServer(t *testing.T) *wsrpc.Server {
+	return mockServerWith(&mockService{t: t})
+}
+
+func mockServerWith(m *mockService) *wsrpc.Server {
+	rpc := wsrpc.NewServer(c)
+	plugin.RegisterTestService(rpc, m)
+	return rpc
+}
+
+// serverWith creates a server with the given Config, allowing clients without
+// an Origin header, and registers m.
+func serverWith(t *testing.T, config wsrpc.Config, m *mockService) *wsrpc.Server {
+	t.Helper()
+	config.AllowMissingOrigin = true
+	rpc := wsrpc.NewServer(config)
+	plugin.RegisterTestService(rpc, m)
+	return rpc
+}
+
+// serve starts an HTTP server for rpc and returns its WebSocket URL.
+func serve(t *testing.T, rpc *wsrpc.Server) string {
+	srv := httptest.NewServer(http.HandlerFunc(rpc.Handle()))
+	t.Cleanup(srv.Close)
+
+	u, _ := url.Parse(srv.URL)
+	u.Scheme = "ws"
+	return u.String()
+}
+
+// dialURL connects to a server started by serve, closing the connection when
+// the test ends. Query parameters such as access_token may be added to addr.
+func dialURL(t *testing.T, addr string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(addr, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
 	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func write(t *testing.T, conn *websocket.Conn, c *wsrpc.Call) {
+	req, err := proto.Marshal(c)
+	assert.NoError(t, err)
+
+	err = conn.WriteMessage(websocket.BinaryMessage, req)
+	assert.NoError(t, err)
+}
+
+func call(t *testing.T, conn *websocket.Conn, id uint32, method string, in proto.Message) {
+	payload, err := proto.Marshal(in)
+	assert.NoError(t, err)
+
+	write(t, conn, &wsrpc.Call{Id: id, Method: method, Payload: payload})
+}
+
+// open starts a call to a client-streaming method.
+func open(t *testing.T, conn *websocket.Conn, id uint32, method string) {
+	write(t, conn, &wsrpc.Call{Id: id, Method: method})
+}
+
+// send streams a message on an open call.
+func send(t *testing.T, conn *websocket.Conn, id uint32, in proto.Message) {
+	payload, err := proto.Marshal(in)
+	assert.NoError(t, err)
+
+	write(t, conn, &wsrpc.Call{Id: id, Payload: payload})
+}
+
+func closeSend(t *testing.T, conn *websocket.Conn, id uint32) {
+	write(t, conn, &wsrpc.Call{Id: id, EndStream: true})
+}
+
+func reply(t *testing.T, conn *websocket.Conn) *wsrpc.Reply {
+	messageType, res, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, websocket.BinaryMessage, messageType)
+
+	r := &wsrpc.Reply{}
+	assert.NoError(t, proto.Unmarshal(res, r))
+	return r
 }
 
 func TestServiceMessage(t *testing.T) {
-	conn := connect(t, mockHandler(t))
+	conn := connect(t, mockServer(t))
+
+	defer conn.Close()
+
+	call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+
+	r := reply(t, conn)
+	out := &plugin.SimpleResponse{}
+	assert.NoError(t, proto.Unmarshal(r.Payload, out))
+	assert.Equal(t, uint32(1), r.Id)
+	assert.Equal(t, int32(codes.OK), r.Status.GetCode())
+	assert.Equal(t, world, out.Text)
+}
+
+func TestMessageSize(t *testing.T) {
+	m := &mockService{
+		t: t,
+		execute: func(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error) {
+			return &plugin.SimpleResponse{Text: in.Text}, nil
+		},
+	}
+	large := strings.Repeat("x", 4096)
+
+	conn := connect(t, mockServerWith(m))
+	defer conn.Close()
+
+	call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: large})
+	out := &plugin.SimpleResponse{}
+	assert.NoError(t, proto.Unmarshal(reply(t, conn).Payload, out))
+	assert.Equal(t, large, out.Text)
+
+	rpc := wsrpc.NewServer(wsrpc.Config{MaxMessageSize: 1024, AllowMissingOrigin: true})
+	plugin.RegisterTestService(rpc, m)
+	limited := connect(t, rpc)
+	defer limited.Close()
+
+	call(t, limited, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: large})
+	_, _, err := limited.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig), "got %v", err)
+}
+
+func TestOutOfOrderReplies(t *testing.T) {
+	release := make(chan struct{})
+	m := &mockService{
+		t: t,
+		execute: func(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error) {
+			if in.Text == "slow" {
+				<-release
+			} else {
+				close(release)
+			}
+			return &plugin.SimpleResponse{Text: in.Text}, nil
+		},
+	}
+	conn := connect(t, mockServerWith(m))
+
+	defer conn.Close()
+
+	call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "slow"})
+	call(t, conn, 2, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "fast"})
+
+	assert.Equal(t, uint32(2), reply(t, conn).Id)
+	assert.Equal(t, uint32(1), reply(t, conn).Id)
+}
+
+func TestErrorStatus(t *testing.T) {
+	m := &mockService{
+		t: t,
+		execute: func(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error) {
+			switch in.Text {
+			case hello:
+				return &plugin.SimpleResponse{Text: world}, nil
+			case "plain":
+				return nil, errors.New("plain error")
+			case "details":
+				st, _ := status.New(codes.NotFound, "no such thing").
+					WithDetails(&plugin.SimpleResponse{Text: world})
+				return nil, st.Err()
+			}
+			return nil, status.Error(codes.PermissionDenied, "go away")
+		},
+	}
+	conn := connect(t, mockServerWith(m))
 
 	defer conn.Close()
 
-	err := conn.WriteMessage(websocket.BinaryMessage, hello)
+	call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "denied"})
+	r := reply(t, conn)
+	assert.Equal(t, uint32(1), r.Id)
+	assert.Equal(t, int32(codes.PermissionDenied), r.Status.Code)
+	assert.Equal(t, "go away", r.Status.Message)
+
+	call(t, conn, 2, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "plain"})
+	r = reply(t, conn)
+	assert.Equal(t, int32(codes.Unknown), r.Status.Code)
+	assert.Equal(t, "plain error", r.Status.Message)
+
+	call(t, conn, 3, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "details"})
+	st := status.FromProto(reply(t, conn).Status)
+	assert.Equal(t, codes.NotFound, st.Code())
+	if assert.Len(t, st.Details(), 1) {
+		assert.Equal(t, world, st.Details()[0].(*plugin.SimpleResponse).Text)
+	}
+
+	call(t, conn, 4, "plugin_test.Test/Missing", &plugin.SimpleRequest{})
+	r = reply(t, conn)
+	assert.Equal(t, uint32(4), r.Id)
+	assert.Equal(t, int32(codes.Unimplemented), r.Status.Code)
+
+	call(t, conn, 5, "plugin_test.Nothing/Execute", &plugin.SimpleRequest{})
+	assert.Equal(t, int32(codes.Unimplemented), reply(t, conn).Status.Code)
+
+	err := conn.WriteMessage(websocket.BinaryMessage, []byte{0xff, 0xff})
 	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.InvalidArgument), reply(t, conn).Status.Code)
 
-	messageType, res, err := conn.ReadMessage()
+	// the connection is still usable after all of the above
+	call(t, conn, 6, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+	assert.Equal(t, int32(codes.OK), reply(t, conn).Status.Code)
+}
+
+func TestServerStream(t *testing.T) {
+	conn := connect(t, mockServer(t))
+
+	defer conn.Close()
+
+	call(t, conn, 7, "plugin_test.Test/Downstream", &plugin.SimpleRequest{Text: "abc"})
+
+	for _, text := range []string{"a", "b", "c"} {
+		r := reply(t, conn)
+		msg := &plugin.StreamMsg{}
+		assert.Equal(t, uint32(7), r.Id)
+		assert.Nil(t, r.Status)
+		assert.NoError(t, proto.Unmarshal(r.Payload, msg))
+		assert.Equal(t, text, msg.Text)
+	}
+
+	r := reply(t, conn)
+	assert.Equal(t, uint32(7), r.Id)
+	assert.Empty(t, r.Payload)
+	assert.Equal(t, int32(codes.OK), r.Status.GetCode())
+
+	call(t, conn, 8, "plugin_test.Test/Downstream", &plugin.SimpleRequest{})
+	r = reply(t, conn)
+	assert.Equal(t, uint32(8), r.Id)
+	assert.Equal(t, int32(codes.InvalidArgument), r.Status.GetCode())
+}
+
+// waitingService blocks each Execute with the text "wait" until its context
+// ends, reporting the context error on the channel.
+func waitingService(t *testing.T, ended chan error) *mockService {
+	return &mockService{
+		t: t,
+		execute: func(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error) {
+			if in.Text == "wait" {
+				<-ctx.Done()
+				ended <- ctx.Err()
+				return nil, ctx.Err()
+			}
+			return &plugin.SimpleResponse{Text: world}, nil
+		},
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ended := make(chan error, 1)
+	conn := connect(t, mockServerWith(waitingService(t, ended)))
+
+	defer conn.Close()
+
+	call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "wait"})
+	write(t, conn, &wsrpc.Call{Id: 1, Cancel: true})
+
+	select {
+	case err := <-ended:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("call context was not canceled")
+	}
+
+	// the ID can be reused and no reply comes for the canceled call
+	call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+	r := reply(t, conn)
+	assert.Equal(t, uint32(1), r.Id)
+	assert.Equal(t, int32(codes.OK), r.Status.GetCode())
+}
+
+func TestDeadline(t *testing.T) {
+	ended := make(chan error, 1)
+	conn := connect(t, mockServerWith(waitingService(t, ended)))
+
+	defer conn.Close()
+
+	payload, _ := proto.Marshal(&plugin.SimpleRequest{Text: "wait"})
+	write(t, conn, &wsrpc.Call{Id: 1, Method: "plugin_test.Test/Execute", Payload: payload, TimeoutMs: 20})
+
+	r := reply(t, conn)
+	assert.Equal(t, uint32(1), r.Id)
+	assert.Equal(t, int32(codes.DeadlineExceeded), r.Status.GetCode())
+	assert.Equal(t, context.DeadlineExceeded, <-ended)
+}
+
+func TestDisconnectCancels(t *testing.T) {
+	ended := make(chan error, 1)
+	conn := connect(t, mockServerWith(waitingService(t, ended)))
+
+	call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "wait"})
+	conn.Close()
+
+	select {
+	case err := <-ended:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("call context was not canceled")
+	}
+}
+
+func TestDeadWriterReleasesSenders(t *testing.T) {
+	const calls = 20
+	var started int32
+	large := strings.Repeat("x", 1024*1024)
+	rpc := wsrpc.NewServer(wsrpc.Config{AllowMissingOrigin: true, SendQueueSize: 1, WriteWait: 500 * time.Millisecond})
+	plugin.RegisterTestService(rpc, &mockService{
+		t: t,
+		execute: func(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error) {
+			atomic.AddInt32(&started, 1)
+			return &plugin.SimpleResponse{Text: large}, nil
+		},
+	})
+	// a small receive buffer so the replies can't all wait in the socket
+	dialer := websocket.Dialer{NetDial: func(network, addr string) (net.Conn, error) {
+		c, err := net.Dial(network, addr)
+		if err == nil {
+			c.(*net.TCPConn).SetReadBuffer(4096)
+		}
+		return c, err
+	}}
+	conn, _, err := dialer.Dial(serve(t, rpc), nil)
 	assert.NoError(t, err)
-	assert.Equal(t, websocket.TextMessage, messageType)
-	assert.Equal(t, world, res)
+	defer conn.Close()
+
+	// replies too large for the socket buffers fill the send queue since
+	// nothing reads them, then malformed calls leave the readPump waiting to
+	// queue its own error replies until the write times out
+	for i := uint32(1); i <= calls; i++ {
+		call(t, conn, i, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+	}
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&started) == calls
+	}, time.Second, 10*time.Millisecond)
+	for i := 0; i < calls; i++ {
+		assert.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte{0xff}))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, rpc.GracefulStop(ctx))
+}
+
+func TestMetadata(t *testing.T) {
+	m := &mockService{
+		t: t,
+		execute: func(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error) {
+			md, ok := wsrpc.MetadataFromContext(ctx)
+			assert.True(t, ok)
+			assert.NoError(t, wsrpc.SetHeader(ctx, metadata.Pairs("locale", md["locale"][0])))
+			assert.NoError(t, wsrpc.SetTrailer(ctx, metadata.Pairs("served-by", "test")))
+			return &plugin.SimpleResponse{Text: md["x-trace-id"][0]}, nil
+		},
+	}
+	conn := connect(t, mockServerWith(m))
+
+	defer conn.Close()
+
+	payload, _ := proto.Marshal(&plugin.SimpleRequest{})
+	write(t, conn, &wsrpc.Call{
+		Id:      1,
+		Method:  "plugin_test.Test/Execute",
+		Payload: payload,
+		Metadata: []*wsrpc.Metadata{
+			{Key: "X-Trace-ID", Values: []string{"abc123"}},
+			{Key: "locale", Values: []string{"en-NZ"}},
+		},
+	})
+
+	r := reply(t, conn)
+	out := &plugin.SimpleResponse{}
+	assert.NoError(t, proto.Unmarshal(r.Payload, out))
+	assert.Equal(t, "abc123", out.Text)
+	assert.Equal(t, []*wsrpc.Metadata{{Key: "locale", Values: []string{"en-NZ"}}}, r.Header)
+	assert.Equal(t, []*wsrpc.Metadata{{Key: "served-by", Values: []string{"test"}}}, r.Trailer)
+}
+
+func TestStreamMetadata(t *testing.T) {
+	conn := connect(t, mockServer(t))
+
+	defer conn.Close()
+
+	payload, _ := proto.Marshal(&plugin.SimpleRequest{Text: "ab"})
+	write(t, conn, &wsrpc.Call{
+		Id:       1,
+		Method:   "plugin_test.Test/Downstream",
+		Payload:  payload,
+		Metadata: []*wsrpc.Metadata{{Key: "header", Values: []string{"first"}}},
+	})
+
+	// header comes on its own before the first message
+	r := reply(t, conn)
+	assert.Equal(t, []*wsrpc.Metadata{{Key: "header", Values: []string{"first"}}}, r.Header)
+	assert.Empty(t, r.Payload)
+	assert.Nil(t, r.Status)
+
+	for range "ab" {
+		r = reply(t, conn)
+		assert.NotEmpty(t, r.Payload)
+		assert.Empty(t, r.Header)
+	}
+
+	r = reply(t, conn)
+	assert.Equal(t, int32(codes.OK), r.Status.GetCode())
+	assert.Empty(t, r.Header)
+	assert.Equal(t, []*wsrpc.Metadata{{Key: "count", Values: []string{"2"}}}, r.Trailer)
+}
+
+func TestFlowControl(t *testing.T) {
+	m := &mockService{t: t}
+	conn := connect(t, mockServerWith(m))
+
+	defer conn.Close()
+
+	payload, _ := proto.Marshal(&plugin.SimpleRequest{Text: "abcd"})
+	// each StreamMsg with one character is three bytes so this allows two
+	write(t, conn, &wsrpc.Call{Id: 1, Method: "plugin_test.Test/Downstream", Payload: payload, Window: 6})
+
+	assert.Equal(t, uint32(1), reply(t, conn).Id)
+	assert.Equal(t, uint32(1), reply(t, conn).Id)
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&m.sent) == 2
+	}, time.Second, 5*time.Millisecond)
+	assert.Never(t, func() bool {
+		return atomic.LoadInt32(&m.sent) > 2
+	}, 50*time.Millisecond, 5*time.Millisecond, "stream should wait for window update")
+
+	write(t, conn, &wsrpc.Call{Id: 1, Window: 100})
+
+	for _, text := range []string{"c", "d"} {
+		msg := &plugin.StreamMsg{}
+		assert.NoError(t, proto.Unmarshal(reply(t, conn).Payload, msg))
+		assert.Equal(t, text, msg.Text)
+	}
+	assert.Equal(t, int32(codes.OK), reply(t, conn).Status.GetCode())
+}
+
+func TestClientStream(t *testing.T) {
+	conn := connect(t, mockServer(t))
+
+	defer conn.Close()
+
+	open(t, conn, 3, "plugin_test.Test/Upstream")
+	for _, text := range []string{"a", "b", "c"} {
+		send(t, conn, 3, &plugin.StreamMsg{Text: text})
+	}
+	closeSend(t, conn, 3)
+
+	r := reply(t, conn)
+	out := &plugin.SimpleResponse{}
+	assert.Equal(t, uint32(3), r.Id)
+	assert.NoError(t, proto.Unmarshal(r.Payload, out))
+	assert.Equal(t, "abc", out.Text)
+
+	r = reply(t, conn)
+	assert.Equal(t, uint32(3), r.Id)
+	assert.Equal(t, int32(codes.OK), r.Status.GetCode())
+}
+
+func TestStreamBufferLimit(t *testing.T) {
+	started := make(chan string, 1)
+	release := make(chan struct{})
+	addr := serve(t, serverWith(t, wsrpc.Config{MaxClientCalls: 1, StreamBufferSize: 200}, blockingService(t, started, release)))
+	conn := dialURL(t, addr)
+
+	// the stream waits behind a blocked call so nothing reads its messages
+	call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "block"})
+	<-started
+	open(t, conn, 2, "plugin_test.Test/Upstream")
+	for i := 0; i < 3; i++ {
+		send(t, conn, 2, &plugin.StreamMsg{Text: strings.Repeat("x", 100)})
+	}
+	closeSend(t, conn, 2)
+
+	close(release)
+	assert.Equal(t, uint32(1), reply(t, conn).Id)
+	r := reply(t, conn)
+	assert.Equal(t, uint32(2), r.Id)
+	assert.Equal(t, int32(codes.ResourceExhausted), r.Status.GetCode())
+	assert.Empty(t, r.Payload)
+}
+
+func TestBidiStreams(t *testing.T) {
+	conn := connect(t, mockServer(t))
+
+	defer conn.Close()
+
+	expect := func(id uint32, text string) {
+		r := reply(t, conn)
+		out := &plugin.StreamMsg2{}
+		assert.Equal(t, id, r.Id)
+		assert.NoError(t, proto.Unmarshal(r.Payload, out))
+		assert.Equal(t, text, out.Text)
+	}
+
+	// two streams interleaved on the same connection
+	open(t, conn, 1, "plugin_test.Test/Bidi")
+	open(t, conn, 2, "plugin_test.Test/Bidi")
+
+	send(t, conn, 1, &plugin.StreamMsg{Text: "one"})
+	expect(1, "one!")
+	send(t, conn, 2, &plugin.StreamMsg{Text: "two"})
+	expect(2, "two!")
+	send(t, conn, 1, &plugin.StreamMsg{Text: "three"})
+	expect(1, "three!")
+
+	closeSend(t, conn, 1)
+	r := reply(t, conn)
+	assert.Equal(t, uint32(1), r.Id)
+	assert.Equal(t, int32(codes.OK), r.Status.GetCode())
+
+	send(t, conn, 2, &plugin.StreamMsg{Text: "four"})
+	expect(2, "four!")
+	closeSend(t, conn, 2)
+	r = reply(t, conn)
+	assert.Equal(t, uint32(2), r.Id)
+	assert.Equal(t, int32(codes.OK), r.Status.GetCode())
+}
+
+func TestServiceInfo(t *testing.T) {
+	info := mockServer(t).GetServiceInfo()
+
+	assert.Contains(t, info, "plugin_test.Test")
+
+	methods := make(map[string]wsrpc.MethodInfo)
+	for _, m := range info["plugin_test.Test"].Methods {
+		methods[m.Name] = m
+	}
+	assert.Len(t, methods, 6)
+	assert.Equal(t, wsrpc.MethodInfo{Name: "Execute"}, methods["Execute"])
+	assert.Equal(t, wsrpc.MethodInfo{Name: "Sequence"}, methods["Sequence"])
+	assert.Equal(t, wsrpc.MethodInfo{Name: "Admin"}, methods["Admin"])
+	assert.Equal(t, wsrpc.MethodInfo{Name: "Downstream", IsServerStream: true}, methods["Downstream"])
+	assert.Equal(t, wsrpc.MethodInfo{Name: "Upstream", IsClientStream: true}, methods["Upstream"])
+	assert.Equal(t, wsrpc.MethodInfo{Name: "Bidi", IsClientStream: true, IsServerStream: true}, methods["Bidi"])
+}
+
+func TestPanicRecovery(t *testing.T) {
+	type report struct {
+		method string
+		p      interface{}
+		stack  []byte
+	}
+	reports := make(chan report, 1)
+	m := &mockService{
+		t: t,
+		execute: func(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error) {
+			if in.Text == "panic" {
+				panic("boom")
+			}
+			return &plugin.SimpleResponse{Text: world}, nil
+		},
+	}
+	rpc := wsrpc.NewServer(wsrpc.Config{
+		AllowMissingOrigin: true,
+		PanicHandler: func(ctx context.Context, method string, p interface{}, stack []byte) {
+			assert.NotNil(t, ctx)
+			reports <- report{method, p, stack}
+		},
+	})
+	plugin.RegisterTestService(rpc, m)
+	conn := connect(t, rpc)
+	defer conn.Close()
+
+	call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "panic"})
+	r := reply(t, conn)
+	assert.Equal(t, uint32(1), r.Id)
+	assert.Equal(t, int32(codes.Internal), r.Status.Code)
+
+	rep := <-reports
+	assert.Equal(t, "plugin_test.Test/Execute", rep.method)
+	assert.Equal(t, "boom", rep.p)
+	assert.NotEmpty(t, rep.stack)
+
+	// the connection and server carry on
+	call(t, conn, 2, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+	assert.Equal(t, int32(codes.OK), reply(t, conn).Status.GetCode())
+}
+
+func TestTryRegisterService(t *testing.T) {
+	rpc := wsrpc.NewServer(c)
+	assert.NoError(t, plugin.TryRegisterTestService(rpc, &mockService{t: t}))
+
+	err := plugin.TryRegisterTestService(rpc, &mockService{t: t})
+	assert.True(t, errors.Is(err, wsrpc.ErrServiceRegistered), "got %v", err)
+
+	err = wsrpc.NewServer(c).TryRegisterService(&plugin.TestServiceDescriptor, "not a service")
+	assert.Error(t, err)
+
+	// malformed descriptors are refused rather than panicking
+	assert.Error(t, rpc.TryRegisterService(nil, &mockService{t: t}))
+	for _, typ := range []interface{}{nil, "plugin_test.Test", &mockService{}} {
+		sd := plugin.TestServiceDescriptor
+		sd.Name, sd.Type = "other", typ
+		assert.Error(t, rpc.TryRegisterService(&sd, &mockService{t: t}), "type %T", typ)
+	}
+}
+
+func TestUnregisterService(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	m := &mockService{
+		t: t,
+		execute: func(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error) {
+			if in.Text == "wait" {
+				close(started)
+				<-release
+			}
+			return &plugin.SimpleResponse{Text: world}, nil
+		},
+	}
+	rpc := mockServerWith(m)
+	conn := connect(t, rpc)
+	defer conn.Close()
+
+	call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "wait"})
+	<-started
+
+	assert.True(t, rpc.UnregisterService("plugin_test.Test"))
+	assert.False(t, rpc.UnregisterService("plugin_test.Test"))
+	assert.NotContains(t, rpc.GetServiceInfo(), "plugin_test.Test")
+
+	call(t, conn, 2, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+	r := reply(t, conn)
+	assert.Equal(t, uint32(2), r.Id)
+	assert.Equal(t, int32(codes.Unimplemented), r.Status.Code)
+
+	// the call in progress still finishes
+	close(release)
+	r = reply(t, conn)
+	assert.Equal(t, uint32(1), r.Id)
+	assert.Equal(t, int32(codes.OK), r.Status.GetCode())
+
+	// and the service can be registered again while running
+	assert.NoError(t, plugin.TryRegisterTestService(rpc, m))
+	call(t, conn, 3, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+	assert.Equal(t, int32(codes.OK), reply(t, conn).Status.GetCode())
 }
@@ -1,8 +1,12 @@
 package wsrpc
 
 import (
-	"log"
+	"errors"
+	"fmt"
 	"reflect"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type (
@@ -19,59 +23,126 @@ type (
 		// provided implementation satisfies the interface requirements.
 		Type    interface{}
 		Methods []MethodMap
+		Streams []StreamMap
 		About   interface{}
 	}
 
-	// ServiceMap matches a service implementation to its methods.
+	// ServiceMap matches a service implementation to its methods. It isn't
+	// changed once registered so calls may keep using it after the service is
+	// removed.
 	ServiceMap struct {
 		service interface{}
 		methods map[string]*MethodMap
+		streams map[string]*StreamMap
 		about   interface{}
 	}
 )
 
+var (
+	// ErrServiceRegistered indicates a service with the same name is already
+	// registered.
+	ErrServiceRegistered = errors.New("wsrpc: service already registered")
+)
+
 // RegisterService registers a service and its implementation to the WebSocket
-// server. It is called from generated code. All services should be registered
-// before the server begins handling requests.
+// server. It is called from generated code. Services may be registered while
+// the server is running, taking effect for calls started afterwards. Failure
+// is fatal; use TryRegisterService to handle the error instead.
 func (s *Server) RegisterService(sd *ServiceDescriptor, implementation interface{}) {
-	requiredType := reflect.TypeOf(sd.Type).Elem()
+	if err := s.TryRegisterService(sd, implementation); err != nil {
+		fatal(s.log, "wsrpc: Server.RegisterService failed", logError, err)
+	}
+}
+
+// TryRegisterService registers a service and its implementation like
+// RegisterService but returns an error rather than exiting if the
+// implementation doesn't satisfy the service interface or the service can't
+// be added.
+func (s *Server) TryRegisterService(sd *ServiceDescriptor, implementation interface{}) error {
+	if sd == nil {
+		return errors.New("wsrpc: nil ServiceDescriptor")
+	}
+	typ := reflect.TypeOf(sd.Type)
+	if typ == nil || typ.Kind() != reflect.Ptr || typ.Elem().Kind() != reflect.Interface {
+		return fmt.Errorf("wsrpc: ServiceDescriptor.Type for %q must be a pointer to the service interface, not %v", sd.Name, typ)
+	}
+	requiredType := typ.Elem()
 	givenType := reflect.TypeOf(implementation)
 
-	if !givenType.Implements(requiredType) {
-		log.Fatalf("wsrpc: Server.RegisterService found the handler of type %v that does not satisfy %v", givenType, requiredType)
+	if givenType == nil || !givenType.Implements(requiredType) {
+		return fmt.Errorf("wsrpc: handler of type %v for %q does not satisfy %v", givenType, sd.Name, requiredType)
 	}
-	s.addService(sd, implementation)
+	return s.addService(sd, implementation)
 }
 
 // addService adds a service implementation to the server.
-func (s *Server) addService(sd *ServiceDescriptor, implementation interface{}) {
+func (s *Server) addService(sd *ServiceDescriptor, implementation interface{}) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if s.active {
-		log.Fatalf("wsrpc: Server.RegisterService after Server.Handle for %q", sd.Name)
-	}
 	if _, ok := s.services[sd.Name]; ok {
-		log.Fatalf("wsrpc: Server.RegisterService found duplicate service registration for %q", sd.Name)
+		return fmt.Errorf("%w: %q", ErrServiceRegistered, sd.Name)
 	}
 	srv := &ServiceMap{
 		service: implementation,
 		methods: make(map[string]*MethodMap),
+		streams: make(map[string]*StreamMap),
 		about:   sd.About,
 	}
 	for i := range sd.Methods {
 		method := &sd.Methods[i]
 		srv.methods[method.Name] = method
 	}
+	for i := range sd.Streams {
+		stream := &sd.Streams[i]
+		srv.streams[stream.Name] = stream
+	}
 	s.services[sd.Name] = srv
+	return nil
+}
+
+// UnregisterService removes the named service, in the form
+// <package>.<service>, from the server. Calls already running finish normally
+// while new calls get status Unimplemented. It reports whether the service was
+// registered.
+func (s *Server) UnregisterService(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.services[name]; !ok {
+		return false
+	}
+	delete(s.services, name)
+	return true
+}
+
+// lookup finds the service matching a fully qualified method name of the form
+// <package>.<service>/<method> and returns it with the method part of the
+// name. The error is an Unimplemented status if the service can't be found.
+func (s *Server) lookup(name string) (*ServiceMap, string, error) {
+	service, method, ok := splitMethodName(name)
+	if !ok {
+		return nil, "", status.Errorf(codes.Unimplemented, "malformed method name: %q", name)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	srv, ok := s.services[service]
+	if !ok {
+		return nil, "", status.Errorf(codes.Unimplemented, "unknown service %v", service)
+	}
+	return srv, method, nil
 }
 
 // GetServiceInfo returns a map from service names to ServiceInfo. Service names
 // include the package names, in the form of <package>.<service>.
 func (s *Server) GetServiceInfo() map[string]ServiceInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	ret := make(map[string]ServiceInfo)
 	for n, srv := range s.services {
-		methods := make([]MethodInfo, 0, len(srv.methods))
+		methods := make([]MethodInfo, 0, len(srv.methods)+len(srv.streams))
 		for m := range srv.methods {
 			methods = append(methods, MethodInfo{
 				Name:           m,
@@ -79,6 +150,13 @@ func (s *Server) GetServiceInfo() map[string]ServiceInfo {
 				IsServerStream: false,
 			})
 		}
+		for m, d := range srv.streams {
+			methods = append(methods, MethodInfo{
+				Name:           m,
+				IsClientStream: d.ClientStreams,
+				IsServerStream: d.ServerStreams,
+			})
+		}
 
 		ret[n] = ServiceInfo{
 			Methods: methods,
@@ -0,0 +1,178 @@
+package wsrpc_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/toba/wsrpc"
+	plugin "github.com/toba/wsrpc/cmd/protoc-gen-gows/test"
+	"google.golang.org/grpc/codes"
+)
+
+// closeCode reads from the connection until it's closed, returning the close
+// code sent by the server.
+func closeCode(t *testing.T, conn *websocket.Conn) int {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if ce, ok := err.(*websocket.CloseError); ok {
+				return ce.Code
+			}
+			t.Fatalf("connection ended without a close frame: %v", err)
+		}
+	}
+}
+
+func TestStop(t *testing.T) {
+	ended := make(chan error, 1)
+	rpc := mockServerWith(waitingService(t, ended))
+	addr := serve(t, rpc)
+
+	conn := dialURL(t, addr)
+
+	call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "wait"})
+	time.Sleep(50 * time.Millisecond)
+	rpc.Stop()
+
+	select {
+	case err := <-ended:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("call context was not canceled")
+	}
+	assert.Equal(t, websocket.CloseGoingAway, closeCode(t, conn))
+
+	_, res, err := websocket.DefaultDialer.Dial(addr, nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	}
+}
+
+func TestGracefulStop(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	m := &mockService{
+		t: t,
+		execute: func(ctx context.Context, in *plugin.SimpleRequest) (*plugin.SimpleResponse, error) {
+			close(started)
+			<-release
+			return &plugin.SimpleResponse{Text: world}, nil
+		},
+	}
+	rpc := mockServerWith(m)
+	conn := dialURL(t, serve(t, rpc))
+
+	call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+	<-started
+
+	stopped := make(chan error)
+	go func() { stopped <- rpc.GracefulStop(context.Background()) }()
+	assert.NotNil(t, reply(t, conn).GoAway)
+
+	// calls started while draining are refused
+	call(t, conn, 2, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+	r := reply(t, conn)
+	assert.Equal(t, uint32(2), r.Id)
+	assert.Equal(t, int32(codes.Unavailable), r.Status.Code)
+
+	// the call in progress finishes before the connection closes
+	close(release)
+	r = reply(t, conn)
+	assert.Equal(t, uint32(1), r.Id)
+	assert.Equal(t, int32(codes.OK), r.Status.GetCode())
+
+	assert.Equal(t, websocket.CloseServiceRestart, closeCode(t, conn))
+	assert.NoError(t, <-stopped)
+}
+
+func TestDrain(t *testing.T) {
+	rpc := mockServer(t)
+	addr := serve(t, rpc)
+	conn := dialURL(t, addr)
+
+	// a stream open before the drain keeps working
+	write(t, conn, &wsrpc.Call{Id: 1, Method: "plugin_test.Test/Bidi"})
+	time.Sleep(50 * time.Millisecond)
+	rpc.Drain("deploy")
+
+	r := reply(t, conn)
+	if assert.NotNil(t, r.GoAway) {
+		assert.Equal(t, "deploy", r.GoAway.Reason)
+	}
+	assert.Equal(t, uint32(0), r.Id)
+	assert.Nil(t, r.Status)
+
+	call(t, conn, 2, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: hello})
+	r = reply(t, conn)
+	assert.Equal(t, uint32(2), r.Id)
+	assert.Equal(t, int32(codes.Unavailable), r.Status.Code)
+
+	send(t, conn, 1, &plugin.StreamMsg{Text: hello})
+	out := &plugin.StreamMsg2{}
+	assert.NoError(t, proto.Unmarshal(reply(t, conn).Payload, out))
+	assert.Equal(t, hello+"!", out.Text)
+
+	_, res, err := websocket.DefaultDialer.Dial(addr, nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	}
+}
+
+func TestGracefulStopTimeout(t *testing.T) {
+	ended := make(chan error, 1)
+	rpc := mockServerWith(waitingService(t, ended))
+	conn := dialURL(t, serve(t, rpc))
+
+	call(t, conn, 1, "plugin_test.Test/Execute", &plugin.SimpleRequest{Text: "wait"})
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, rpc.GracefulStop(ctx))
+	assert.Equal(t, context.Canceled, <-ended)
+	assert.Equal(t, websocket.CloseGoingAway, closeCode(t, conn))
+}
+
+func TestGracefulStopFlushesQueue(t *testing.T) {
+	const count = 20000
+	rpc := mockServer(t)
+	conn := dialURL(t, serve(t, rpc))
+
+	call(t, conn, 1, "plugin_test.Test/Downstream", &plugin.SimpleRequest{Text: strings.Repeat("x", count)})
+
+	// once the stream has started, replies back up in the send queue while
+	// nothing reads them
+	assert.NotEmpty(t, reply(t, conn).Payload)
+	assert.NoError(t, rpc.GracefulStop(context.Background()))
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	messages := 1
+	var last *wsrpc.Reply
+	for {
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			assert.True(t, websocket.IsCloseError(err, websocket.CloseServiceRestart), "got %v", err)
+			break
+		}
+		r := &wsrpc.Reply{}
+		assert.NoError(t, proto.Unmarshal(b, r))
+		if len(r.Payload) > 0 {
+			messages++
+		}
+		if r.Status != nil {
+			last = r
+		}
+	}
+	assert.Equal(t, count, messages)
+	if assert.NotNil(t, last, "no final status") {
+		assert.Equal(t, int32(codes.OK), last.Status.GetCode())
+	}
+}
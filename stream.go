@@ -0,0 +1,298 @@
+package wsrpc
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ServerStream defines the server-side behavior of a streaming RPC. It is
+// embedded by the typed streams in generated code.
+type ServerStream interface {
+	// SetHeader sets the header metadata. It may be called multiple times and
+	// the metadata is merged. It's an error to call it after the header has
+	// been sent, which happens with the first SendMsg, the end of the call or
+	// an explicit SendHeader.
+	SetHeader(metadata.MD) error
+	// SendHeader sends the header metadata merged with any set by SetHeader.
+	// It fails if called multiple times.
+	SendHeader(metadata.MD) error
+	// SetTrailer sets the trailer metadata which will be sent with the status
+	// when the call ends. It may be called multiple times and the metadata is
+	// merged.
+	SetTrailer(metadata.MD)
+	// Context returns the context for this stream.
+	Context() context.Context
+	// SendMsg sends a message to the client. It is safe to have a goroutine
+	// calling SendMsg and another goroutine calling RecvMsg on the same stream
+	// at the same time, but not to call SendMsg on the same stream from
+	// different goroutines.
+	SendMsg(m interface{}) error
+	// RecvMsg blocks until it receives a message into m or the client has no
+	// more messages to send, in which case it returns io.EOF.
+	RecvMsg(m interface{}) error
+}
+
+// serverStream implements ServerStream for a call on a Client connection.
+// Each message sent is wrapped in a Reply tagged with the call ID. Every call,
+// including unary, has a serverStream so later messages from the client can
+// be matched to it.
+type serverStream struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	id     uint32
+	client *Client
+	codec  grpc.Codec
+	req    *Request
+	window *window // flow control for messages sent to the client
+
+	mu        sync.Mutex
+	recv      [][]byte      // messages received from the client but not read
+	recvBytes int           // total size of recv
+	recvDone  bool          // whether the client has half-closed the stream
+	canceled  bool          // whether the client canceled the call
+	overflow  bool          // whether the client sent more than could be buffered
+	notify    chan struct{} // signalled when recv or recvDone change
+
+	header     metadata.MD
+	headerSent bool
+	trailer    metadata.MD
+}
+
+// newServerStream creates the stream for a call. Its context carries the call
+// metadata and is canceled when the call finishes, the client cancels it, its
+// timeout passes or the client connection closes.
+func newServerStream(c *Client, req *Request) *serverStream {
+	size := req.call.Window
+	if size == 0 {
+		size = c.server.config.InitialWindowSize
+	}
+	ss := &serverStream{
+		id:     req.ID,
+		client: c,
+		codec:  c.server.codec,
+		req:    req,
+		window: newWindow(size),
+		notify: make(chan struct{}, 1),
+	}
+	ctx := context.WithValue(c.ctx, streamKey{}, ss)
+	ctx = metadata.NewIncomingContext(ctx, fromMetadata(req.call.Metadata))
+
+	if req.call.TimeoutMs > 0 {
+		ss.ctx, ss.cancel = context.WithTimeout(ctx, time.Duration(req.call.TimeoutMs)*time.Millisecond)
+	} else {
+		ss.ctx, ss.cancel = context.WithCancel(ctx)
+	}
+	return ss
+}
+
+// deliver queues a message from the client, grants window credit,
+// half-closes the stream or cancels the call.
+func (ss *serverStream) deliver(call *Call) {
+	if call.Cancel {
+		ss.mu.Lock()
+		ss.canceled = true
+		ss.mu.Unlock()
+
+		ss.cancel()
+		ss.client.closeStream(ss)
+		return
+	}
+	if call.Window > 0 {
+		ss.window.add(call.Window)
+	}
+
+	ss.mu.Lock()
+	if call.EndStream {
+		ss.recvDone = true
+	} else if call.Window == 0 && !ss.recvDone && !ss.overflow {
+		ss.recvBytes += len(call.Payload)
+		ss.recv = append(ss.recv, call.Payload)
+		if ss.recvBytes > ss.client.server.config.StreamBufferSize {
+			// The client is sending faster than the handler reads. Drop the
+			// buffer and end the call rather than growing without limit.
+			ss.overflow = true
+			ss.recv = nil
+			ss.recvBytes = 0
+			defer ss.cancel()
+		}
+	}
+	ss.mu.Unlock()
+
+	select {
+	case ss.notify <- struct{}{}:
+	default:
+	}
+}
+
+// single makes the payload the only message the stream will receive, as for
+// unary and server-streaming methods where the client doesn't stream.
+func (ss *serverStream) single(payload []byte) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.recv = [][]byte{payload}
+	ss.recvDone = true
+}
+
+// overflowed returns a ResourceExhausted status error if the client sent more
+// messages than the stream could buffer.
+func (ss *serverStream) overflowed() error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if !ss.overflow {
+		return nil
+	}
+	return status.Errorf(codes.ResourceExhausted, "wsrpc: more than %d bytes of messages received but not read", ss.client.server.config.StreamBufferSize)
+}
+
+// canceledByClient indicates whether the client canceled the call and so
+// expects no more replies.
+func (ss *serverStream) canceledByClient() bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.canceled
+}
+
+func (ss *serverStream) SetHeader(md metadata.MD) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.headerSent {
+		return status.Error(codes.Internal, "wsrpc: the header has already been sent")
+	}
+	ss.header = metadata.Join(ss.header, md)
+	return nil
+}
+
+func (ss *serverStream) SendHeader(md metadata.MD) error {
+	if err := ss.SetHeader(md); err != nil {
+		return err
+	}
+	return ss.sendHeader()
+}
+
+// sendHeader sends the header in its own Reply unless it's already been sent
+// or is empty.
+func (ss *serverStream) sendHeader() error {
+	header, ok := ss.takeHeader()
+	if !ok || len(header) == 0 {
+		return nil
+	}
+	res, err := ss.codec.Marshal(&Reply{Id: ss.id, Header: toMetadata(header)})
+	if err != nil {
+		return status.Errorf(codes.Internal, "wsrpc: error while marshaling: %v", err)
+	}
+	if !ss.client.send(res) {
+		return ErrClientClosed
+	}
+	return nil
+}
+
+// takeHeader marks the header sent, returning it if it wasn't already.
+func (ss *serverStream) takeHeader() (metadata.MD, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.headerSent {
+		return nil, false
+	}
+	ss.headerSent = true
+	return ss.header, true
+}
+
+func (ss *serverStream) SetTrailer(md metadata.MD) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.trailer = metadata.Join(ss.trailer, md)
+}
+
+// last builds the final Reply for the call with whatever header hasn't been
+// sent yet and the trailer.
+func (ss *serverStream) last(payload []byte) *Reply {
+	res := &Reply{Id: ss.id, Payload: payload}
+	if header, ok := ss.takeHeader(); ok {
+		res.Header = toMetadata(header)
+	}
+	ss.mu.Lock()
+	res.Trailer = toMetadata(ss.trailer)
+	ss.mu.Unlock()
+	return res
+}
+
+func (ss *serverStream) Context() context.Context {
+	return ss.ctx
+}
+
+// SendMsg waits for the client to have room in its window for the message
+// before queueing it. The header is sent first if it hasn't been already.
+func (ss *serverStream) SendMsg(m interface{}) error {
+	if err := ss.sendHeader(); err != nil {
+		return err
+	}
+	payload, err := ss.codec.Marshal(m)
+	if err != nil {
+		return status.Errorf(codes.Internal, "wsrpc: error while marshaling: %v", err)
+	}
+	if err := ss.window.take(ss.ctx, ss.client.done, len(payload)); err != nil {
+		return err
+	}
+	res, err := ss.codec.Marshal(&Reply{Id: ss.id, Payload: payload})
+	if err != nil {
+		return status.Errorf(codes.Internal, "wsrpc: error while marshaling: %v", err)
+	}
+	if !ss.client.send(res) {
+		return ErrClientClosed
+	}
+	return nil
+}
+
+func (ss *serverStream) RecvMsg(m interface{}) error {
+	for {
+		if err := ss.overflowed(); err != nil {
+			return err
+		}
+		ss.mu.Lock()
+		if len(ss.recv) > 0 {
+			payload := ss.recv[0]
+			ss.recv = ss.recv[1:]
+			ss.recvBytes -= len(payload)
+			ss.mu.Unlock()
+
+			if err := ss.codec.Unmarshal(payload, m); err != nil {
+				return status.Errorf(codes.Internal, "wsrpc: error unmarshalling request: %v", err)
+			}
+			ss.req.Message = m
+			return nil
+		}
+		done := ss.recvDone
+		ss.mu.Unlock()
+
+		if done {
+			return io.EOF
+		}
+
+		select {
+		case <-ss.notify:
+		case <-ss.client.done:
+			return ErrClientClosed
+		case <-ss.ctx.Done():
+			return contextError(ss.ctx.Err())
+		}
+	}
+}
+
+// contextError converts an error from a context into a status error. Other
+// errors are reported with code Unknown.
+func contextError(err error) error {
+	switch err {
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case context.Canceled:
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return status.Error(codes.Unknown, err.Error())
+}